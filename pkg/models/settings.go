@@ -5,19 +5,50 @@ import (
 	"fmt"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/proxy"
+)
+
+// Ways to authenticate at Aria
+const (
+	// AuthModePassword acquires tokens with username and password
+	AuthModePassword = "password"
+	// AuthModeToken uses a pre-issued token
+	AuthModeToken = "token"
+	// AuthModeTokenExchange fetches short-lived tokens from an exchange URL, like a vault issuing tokens to service accounts
+	AuthModeTokenExchange = "tokenExchange"
+	// AuthModeForwardIdentity authenticates every Grafana user with their OAuth identity at an SSO auth source
+	AuthModeForwardIdentity = "forwardIdentity"
 )
 
 type PluginSettings struct {
 	Host          string                `json:"host"`
+	AuthMode      string                `json:"authMode"`
 	Username      string                `json:"username"`
 	AuthSource    string                `json:"authSource"`
 	TlsSkipVerify bool                  `json:"tlsSkipVerify"`
+	TlsServerName string                `json:"serverName"`
+	ProxyUrl      string                `json:"proxyUrl"`
+	RateLimit     float64               `json:"rateLimit"`
+	OauthPassThru bool                  `json:"oauthPassThru"`
 	Secrets       *SecretPluginSettings `json:"-"`
+	// Timeouts in seconds, defaults apply when they are not set
+	ConnectTimeout int `json:"connectTimeout"`
+	RequestTimeout int `json:"requestTimeout"`
+	QueryTimeout   int `json:"queryTimeout"`
+	// ProxyOptions configure Grafana's secure SOCKS proxy, they depend on the Grafana configuration of the request
+	ProxyOptions *proxy.Options `json:"-"`
 }
 
 type SecretPluginSettings struct {
 	Password string `json:"password"`
-	Token    *string
+	// Token is a pre-issued token, with a token exchange URL it authenticates the exchange
+	Token            *string `json:"apiToken"`
+	TokenExchangeUrl string  `json:"tokenExchangeUrl"`
+	// TlsCACert is a PEM bundle of the certificate authorities trusted for Aria
+	TlsCACert string `json:"tlsCACert"`
+	// TlsClientCert and TlsClientKey are the PEM encoded certificate and key presented to Aria
+	TlsClientCert string `json:"tlsClientCert"`
+	TlsClientKey  string `json:"tlsClientKey"`
 }
 
 func LoadPluginSettings(source backend.DataSourceInstanceSettings) (*PluginSettings, error) {
@@ -28,12 +59,23 @@ func LoadPluginSettings(source backend.DataSourceInstanceSettings) (*PluginSetti
 	}
 
 	settings.Secrets = loadSecretPluginSettings(source.DecryptedSecureJSONData)
+	if settings.AuthMode == "" {
+		settings.AuthMode = AuthModePassword
+	}
 
 	return &settings, nil
 }
 
 func loadSecretPluginSettings(source map[string]string) *SecretPluginSettings {
-	return &SecretPluginSettings{
-		Password: source["password"],
+	secrets := &SecretPluginSettings{
+		Password:         source["password"],
+		TokenExchangeUrl: source["tokenExchangeUrl"],
+		TlsCACert:        source["tlsCACert"],
+		TlsClientCert:    source["tlsClientCert"],
+		TlsClientKey:     source["tlsClientKey"],
+	}
+	if token := source["apiToken"]; token != "" {
+		secrets.Token = &token
 	}
+	return secrets
 }
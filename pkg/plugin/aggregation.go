@@ -0,0 +1,174 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	AggregateSum        = "sum"
+	AggregateAvg        = "avg"
+	AggregateMin        = "min"
+	AggregateMax        = "max"
+	AggregateCount      = "count"
+	AggregatePercentile = "percentile"
+)
+
+// validate reports configuration errors of an aggregation, an empty operator disables it.
+func (a Aggregation) validate() error {
+	switch a.Operator {
+	case "", AggregateSum, AggregateAvg, AggregateMin, AggregateMax, AggregateCount:
+		return nil
+	case AggregatePercentile:
+		if a.Percentile < 0 || a.Percentile > 100 {
+			return fmt.Errorf("percentile must be between 0 and 100, got %v", a.Percentile)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported aggregation operator %q", a.Operator)
+}
+
+// aggregateFrames combines time series frames into one series per group. Groups are formed by the values
+// of the group by labels, series of different metrics are never combined. Series are aligned to the rollup
+// interval of their data, so the result does not depend on how many points a panel shows.
+func aggregateFrames(frames data.Frames, aggregation Aggregation) (data.Frames, error) {
+	if aggregation.Operator == "" {
+		return frames, nil
+	}
+	if err := aggregation.validate(); err != nil {
+		return nil, err
+	}
+
+	type group struct {
+		labels  data.Labels
+		members map[string]series
+		values  map[int64][]float64
+	}
+	groups := make(map[string]*group)
+	for _, frame := range frames {
+		frameLabels, s, ok := frameSeries(frame)
+		if !ok {
+			continue
+		}
+		labels := data.Labels{"__name__": frameLabels["__name__"]}
+		// Time shifted series are aggregated separately from the current ones
+		if offset, ok := frameLabels["offset"]; ok {
+			labels["offset"] = offset
+		}
+		for _, label := range aggregation.GroupBy {
+			labels[label] = frameLabels[label]
+		}
+		key := labels.String()
+		g, ok := groups[key]
+		if !ok {
+			g = &group{labels: labels, members: make(map[string]series), values: make(map[int64][]float64)}
+			groups[key] = g
+		}
+		// Changing properties split a resource into several frames, which are merged back here
+		member, ok := g.members[frameLabels["resourceId"]]
+		if !ok {
+			member = make(series, len(s))
+			g.members[frameLabels["resourceId"]] = member
+		}
+		for ts, v := range s {
+			member[ts] = v
+		}
+	}
+	for _, g := range groups {
+		step := collectionInterval
+		for _, member := range g.members {
+			timestamps, _ := member.sorted()
+			step = max(step, medianInterval(timestamps))
+		}
+		for _, member := range g.members {
+			for ts, v := range member.bucketed(step, bucketReducer(aggregation.Operator)) {
+				g.values[ts] = append(g.values[ts], v)
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := make(data.Frames, 0, len(groups))
+	for _, key := range keys {
+		g := groups[key]
+		aggregated := make(series, len(g.values))
+		for ts, points := range g.values {
+			aggregated[ts] = aggregatePoints(aggregation, points)
+		}
+		name := fmt.Sprintf("%s(%s)", aggregation.Operator, g.labels["__name__"])
+		if aggregation.Operator == AggregatePercentile {
+			name = fmt.Sprintf("%s%v(%s)", aggregation.Operator, aggregation.Percentile, g.labels["__name__"])
+		}
+		g.labels["__name__"] = name
+		frame := seriesFrame(name, g.labels, aggregated)
+		result = append(result, frame)
+	}
+	backend.Logger.Debug("Aggregated series", "operator", aggregation.Operator, "groupBy", strings.Join(aggregation.GroupBy, ","), "series", len(frames), "groups", len(result))
+	return result, nil
+}
+
+// bucketReducer combines the samples of one resource within a step, min and max keep the extremes and
+// other operators the mean, so samples are not counted more than once
+func bucketReducer(operator string) func([]float64) float64 {
+	switch operator {
+	case AggregateMin, AggregateMax:
+		return func(points []float64) float64 { return aggregate(operator, points) }
+	}
+	return func(points []float64) float64 { return aggregate(AggregateAvg, points) }
+}
+
+// bucketed returns the series with timestamps truncated to the step, all samples within a step are
+// combined by reduce. NaN values are dropped.
+func (s series) bucketed(step time.Duration, reduce func([]float64) float64) series {
+	stepMilli := step.Milliseconds()
+	if stepMilli <= 0 {
+		return s
+	}
+	buckets := make(map[int64][]float64, len(s))
+	for ts, v := range s {
+		if math.IsNaN(v) {
+			continue
+		}
+		bucket := ts - ts%stepMilli
+		buckets[bucket] = append(buckets[bucket], v)
+	}
+	result := make(series, len(buckets))
+	for bucket, points := range buckets {
+		result[bucket] = reduce(points)
+	}
+	return result
+}
+
+func aggregatePoints(aggregation Aggregation, points []float64) float64 {
+	if aggregation.Operator == AggregatePercentile {
+		return percentile(points, aggregation.Percentile)
+	}
+	return aggregate(aggregation.Operator, points)
+}
+
+// percentile interpolates linearly between the closest ranks
+func percentile(points []float64, p float64) float64 {
+	if len(points) == 0 {
+		return math.NaN()
+	}
+	sorted := append([]float64(nil), points...)
+	sort.Float64s(sorted)
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	if lower == upper {
+		return sorted[lower]
+	}
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
@@ -0,0 +1,129 @@
+package plugin
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestAggregateFrames(t *testing.T) {
+	const minute = int64(60000)
+	frame := func(resourceId string, host string, s series) *data.Frame {
+		return seriesFrame("cpu", data.Labels{"__name__": "cpu", "resourceId": resourceId, "host": host}, s)
+	}
+	// Resources are collected every 5 minutes at their own offset
+	frames := data.Frames{
+		frame("a", "h1", series{1 * minute: 1, 6 * minute: 2, 11 * minute: 3}),
+		frame("b", "h1", series{3 * minute: 10, 8 * minute: 20, 13 * minute: 30}),
+		frame("c", "h2", series{2 * minute: 100, 7 * minute: 200}),
+	}
+	tests := []struct {
+		name        string
+		aggregation Aggregation
+		expected    map[string]series
+	}{
+		{
+			name:        "sum of all",
+			aggregation: Aggregation{Operator: AggregateSum},
+			expected:    map[string]series{"sum(cpu)": {0: 111, 5 * minute: 222, 10 * minute: 33}},
+		},
+		{
+			name:        "max by host",
+			aggregation: Aggregation{Operator: AggregateMax, GroupBy: []string{"host"}},
+			expected: map[string]series{
+				"h1": {0: 10, 5 * minute: 20, 10 * minute: 30},
+				"h2": {0: 100, 5 * minute: 200},
+			},
+		},
+		{
+			name:        "count",
+			aggregation: Aggregation{Operator: AggregateCount},
+			expected:    map[string]series{"count(cpu)": {0: 3, 5 * minute: 3, 10 * minute: 2}},
+		},
+		{
+			name:        "median",
+			aggregation: Aggregation{Operator: AggregatePercentile, Percentile: 50},
+			expected:    map[string]series{"percentile50(cpu)": {0: 10, 5 * minute: 20, 10 * minute: 16.5}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := aggregateFrames(frames, tt.aggregation)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result) != len(tt.expected) {
+				t.Fatalf("expected %d frames, got %d", len(tt.expected), len(result))
+			}
+			for _, f := range result {
+				labels, s, _ := frameSeries(f)
+				expected, ok := tt.expected[labels["__name__"]]
+				if !ok {
+					expected = tt.expected[labels["host"]]
+				}
+				assertSeries(t, expected, s)
+			}
+		})
+	}
+}
+
+// Samples of one resource in the same step are combined instead of replacing each other
+func TestAggregateFramesReducesSamplesWithinStep(t *testing.T) {
+	const minute = int64(60000)
+	frames := data.Frames{
+		seriesFrame("cpu", data.Labels{"__name__": "cpu", "resourceId": "a"}, series{0: 2, 1 * minute: 4, 2 * minute: 6, 10 * minute: 1}),
+		seriesFrame("cpu", data.Labels{"__name__": "cpu", "resourceId": "b"}, series{0: 1, 10 * minute: 1}),
+	}
+	tests := []struct {
+		operator string
+		expected series
+	}{
+		{operator: AggregateSum, expected: series{0: 5, 10 * minute: 2}},
+		{operator: AggregateMax, expected: series{0: 6, 10 * minute: 1}},
+		{operator: AggregateMin, expected: series{0: 1, 10 * minute: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.operator, func(t *testing.T) {
+			result, err := aggregateFrames(frames, Aggregation{Operator: tt.operator})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result) != 1 {
+				t.Fatalf("expected one frame, got %d", len(result))
+			}
+			_, s, _ := frameSeries(result[0])
+			assertSeries(t, tt.expected, s)
+		})
+	}
+}
+
+func TestAggregationValidate(t *testing.T) {
+	tests := []struct {
+		aggregation Aggregation
+		valid       bool
+	}{
+		{aggregation: Aggregation{}, valid: true},
+		{aggregation: Aggregation{Operator: AggregateAvg}, valid: true},
+		{aggregation: Aggregation{Operator: AggregatePercentile, Percentile: 95}, valid: true},
+		{aggregation: Aggregation{Operator: AggregatePercentile, Percentile: 101}},
+		{aggregation: Aggregation{Operator: "median"}},
+	}
+	for _, tt := range tests {
+		if err := tt.aggregation.validate(); (err == nil) != tt.valid {
+			t.Errorf("%+v: expected valid %v, got %v", tt.aggregation, tt.valid, err)
+		}
+	}
+}
+
+func assertSeries(t *testing.T, expected series, actual series) {
+	t.Helper()
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	for ts, v := range expected {
+		if got, ok := actual[ts]; !ok || math.Abs(got-v) > 1e-9 {
+			t.Errorf("at %d expected %v, got %v", ts, v, actual)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"swisscom-vmwareariaoperations-datasource/pkg/api"
+	"swisscom-vmwareariaoperations-datasource/pkg/models"
+	"sync/atomic"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// Tokens are refreshed this long before they expire, so requests in flight do not fail
+	tokenRefreshMargin = 5 * time.Minute
+	// Aria issues tokens valid for six hours unless configured otherwise
+	defaultTokenValidity = 6 * time.Hour
+	releaseTokenTimeout  = 10 * time.Second
+	// A pre-issued token has no known expiry, it is only replaced after Aria rejected it
+	staticTokenValidity = 24 * time.Hour
+)
+
+// ariaToken is an OpsToken together with its expiry
+type ariaToken struct {
+	Value     string
+	ExpiresAt time.Time
+}
+
+func (t *ariaToken) expiresWithin(d time.Duration) bool {
+	return time.Now().Add(d).After(t.ExpiresAt)
+}
+
+var errReleased = errors.New("the datasource was disposed and released its tokens")
+
+// tokenSource acquires OpsTokens and replaces them before they expire. The current token is read without
+// locking, a refreshed token is swapped in atomically and concurrent refreshes share one login.
+type tokenSource struct {
+	config *models.PluginSettings
+	// client is shared with data requests, so logins use the same TLS and proxy settings
+	client  *http.Client
+	acquire func(ctx context.Context) (*ariaToken, error)
+	token   atomic.Pointer[ariaToken]
+	logins  singleflight.Group
+	// released sources do not log in anymore
+	released atomic.Bool
+}
+
+// newTokenSource creates the token source of the datasource, exchange is the client of the token exchange
+// if one is configured.
+func newTokenSource(config *models.PluginSettings, client *http.Client, exchange *http.Client) *tokenSource {
+	s := &tokenSource{config: config, client: client}
+	s.acquire = func(ctx context.Context) (*ariaToken, error) {
+		return acquireToken(ctx, config, client, exchange)
+	}
+	return s
+}
+
+// Token returns a valid token, acquiring a new one when there is none or it is about to expire.
+func (s *tokenSource) Token(ctx context.Context) (*ariaToken, error) {
+	if token := s.token.Load(); token != nil && !token.expiresWithin(tokenRefreshMargin) {
+		return token, nil
+	}
+	// The login is shared by concurrent callers, so it is not cancelled with the request which started it.
+	// It ends with the request timeout of Aria requests, callers stop waiting when their request is cancelled.
+	login := context.WithoutCancel(ctx)
+	result := s.logins.DoChan("token", func() (interface{}, error) {
+		// Another caller may have refreshed the token while this one was waiting
+		current := s.token.Load()
+		if current != nil && !current.expiresWithin(tokenRefreshMargin) {
+			return current, nil
+		}
+		if s.released.Load() {
+			return nil, errReleased
+		}
+		if current != nil {
+			backend.Logger.Debug("Refreshing token before expiry", "expiresAt", current.ExpiresAt)
+		}
+		token, err := s.acquire(login)
+		if err != nil {
+			return nil, err
+		}
+		s.token.Store(token)
+		// A login which finished after Release is undone, either here or by Release
+		if s.released.Load() && s.token.CompareAndSwap(token, nil) {
+			s.release(token)
+			return nil, errReleased
+		}
+		return token, nil
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-result:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*ariaToken), nil
+	}
+}
+
+// validateAuth reports settings missing for the authentication mode of the datasource.
+func validateAuth(config *models.PluginSettings) error {
+	switch config.AuthMode {
+	case models.AuthModePassword:
+		if config.Secrets.Password == "" {
+			return fmt.Errorf("Password is missing")
+		}
+		if config.Username == "" {
+			return fmt.Errorf("Username is missing")
+		}
+		if config.AuthSource == "" {
+			return fmt.Errorf("AuthSource is missing")
+		}
+	case models.AuthModeToken:
+		if config.Secrets.Token == nil {
+			return fmt.Errorf("API token is missing")
+		}
+	case models.AuthModeTokenExchange:
+		if config.Secrets.TokenExchangeUrl == "" {
+			return fmt.Errorf("Token exchange URL is missing")
+		}
+		return validateExchangeUrl(config)
+	case models.AuthModeForwardIdentity:
+		if !config.OauthPassThru {
+			return fmt.Errorf("Forward OAuth identity must be enabled to forward the identity of Grafana users")
+		}
+		if config.Secrets.TokenExchangeUrl != "" {
+			return validateExchangeUrl(config)
+		}
+		if config.AuthSource == "" {
+			return fmt.Errorf("AuthSource is missing, it must be an SSO auth source of Aria")
+		}
+	default:
+		return fmt.Errorf("Unsupported authentication mode %q", config.AuthMode)
+	}
+	return nil
+}
+
+func validateExchangeUrl(config *models.PluginSettings) error {
+	exchangeUrl, err := url.Parse(config.Secrets.TokenExchangeUrl)
+	if err != nil || (exchangeUrl.Scheme != "http" && exchangeUrl.Scheme != "https") || exchangeUrl.Host == "" {
+		return fmt.Errorf("Token exchange URL must be an absolute http or https URL")
+	}
+	return nil
+}
+
+// acquireToken gets a token the way the datasource is configured to authenticate.
+func acquireToken(ctx context.Context, config *models.PluginSettings, hc *http.Client, exchange *http.Client) (*ariaToken, error) {
+	switch config.AuthMode {
+	case models.AuthModeToken:
+		if config.Secrets.Token == nil {
+			return nil, fmt.Errorf("API token is missing")
+		}
+		return &ariaToken{Value: *config.Secrets.Token, ExpiresAt: time.Now().Add(staticTokenValidity)}, nil
+	case models.AuthModeTokenExchange:
+		return exchangeToken(ctx, config, exchange, config.Secrets.Token)
+	case models.AuthModePassword:
+		return auth(ctx, config, hc, config.Username, config.Secrets.Password)
+	case models.AuthModeForwardIdentity:
+		return nil, errMissingIdentity
+	}
+	return nil, fmt.Errorf("unsupported authentication mode %q", config.AuthMode)
+}
+
+// exchangeResponse covers the token responses of Aria and of OAuth token endpoints
+type exchangeResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+	// Validity is the expiry in epoch milliseconds
+	Validity  int64      `json:"validity"`
+	ExpiresIn int64      `json:"expires_in"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// exchangeToken fetches a token from the exchange URL, authenticated with the bearer token if there is one.
+// The exchange answers with JSON or with the plain token.
+func exchangeToken(ctx context.Context, config *models.PluginSettings, hc *http.Client, bearer *string) (*ariaToken, error) {
+	if config.Secrets.TokenExchangeUrl == "" || hc == nil {
+		return nil, fmt.Errorf("token exchange URL is missing")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, config.Secrets.TokenExchangeUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token exchange URL: %w", err)
+	}
+	if err := NewRequestEditor(bearer)(ctx, req); err != nil {
+		return nil, err
+	}
+	backend.Logger.Debug("Exchanging token", "url", req.URL.Host)
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token exchange failed with status %d: %s", resp.StatusCode, string(body))
+	}
+	token := &ariaToken{ExpiresAt: time.Now().Add(defaultTokenValidity)}
+	var exchanged exchangeResponse
+	if err := json.Unmarshal(body, &exchanged); err != nil {
+		token.Value = strings.TrimSpace(string(body))
+	} else {
+		token.Value = exchanged.Token
+		if token.Value == "" {
+			token.Value = exchanged.AccessToken
+		}
+		switch {
+		case exchanged.Validity > 0:
+			token.ExpiresAt = time.UnixMilli(exchanged.Validity)
+		case exchanged.ExpiresIn > 0:
+			token.ExpiresAt = time.Now().Add(time.Duration(exchanged.ExpiresIn) * time.Second)
+		case exchanged.ExpiresAt != nil:
+			token.ExpiresAt = *exchanged.ExpiresAt
+		}
+	}
+	if token.Value == "" {
+		return nil, fmt.Errorf("token exchange returned no token")
+	}
+	backend.Logger.Debug("Received token from exchange", "expiresAt", token.ExpiresAt)
+	return token, nil
+}
+
+// ownsTokens reports whether tokens were acquired from Aria by the datasource, only these are released
+func ownsTokens(config *models.PluginSettings) bool {
+	switch config.AuthMode {
+	case models.AuthModePassword:
+		return true
+	case models.AuthModeForwardIdentity:
+		return config.Secrets.TokenExchangeUrl == ""
+	}
+	return false
+}
+
+// Invalidate forgets a token rejected by Aria, unless it was already replaced by another request.
+func (s *tokenSource) Invalidate(token *ariaToken) {
+	s.token.CompareAndSwap(token, nil)
+}
+
+// Release invalidates the current token in Aria, so tokens of disposed instances can not be reused. The
+// source does not log in again afterwards.
+func (s *tokenSource) Release() {
+	s.released.Store(true)
+	if token := s.token.Swap(nil); token != nil {
+		s.release(token)
+	}
+}
+
+// release invalidates the token in Aria
+func (s *tokenSource) release(token *ariaToken) {
+	if token.expiresWithin(0) {
+		return
+	}
+	// Pre-issued and exchanged tokens are owned by whoever issued them
+	if !ownsTokens(s.config) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTokenTimeout)
+	defer cancel()
+	c, err := api.NewClientWithResponses(s.config.Host, api.WithHTTPClient(s.client), api.WithRequestEditorFn(func(ctx context.Context, req *http.Request) error {
+		req.Header.Set("Authorization", fmt.Sprintf("OpsToken %s", token.Value))
+		req.Header.Set("accept", "application/json")
+		return nil
+	}))
+	if err != nil {
+		backend.Logger.Warn("Unable to create client to release token", "error", err)
+		return
+	}
+	resp, err := c.ReleaseTokenUsingPOSTWithResponse(ctx)
+	if err != nil {
+		backend.Logger.Warn("Unable to release token", "error", err)
+		return
+	}
+	if resp.StatusCode() != http.StatusOK {
+		backend.Logger.Warn("Unexpected status releasing token", "StatusCode", resp.StatusCode(), "body", string(resp.Body))
+		return
+	}
+	backend.Logger.Debug("Released token", "url", s.config.Host)
+}
+
+// authTransport adds the OpsToken to every request and retries a request once with a new token when
+// Aria rejects the current one.
+type authTransport struct {
+	base   http.RoundTripper
+	tokens *tokenSource
+	// users holds the tokens of Grafana users when their identity is forwarded
+	users *userTokenCache
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tokens, err := t.tokenSource(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	token, err := tokens.Token(req.Context())
+	if err != nil {
+		return nil, fmt.Errorf("unable to authenticate: %w", err)
+	}
+	resp, err := t.base.RoundTrip(withToken(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	// Requests whose body can not be read again are not retried
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	backend.Logger.Debug("Token rejected, authenticating again", "url", req.URL.Path)
+	tokens.Invalidate(token)
+	token, err = tokens.Token(req.Context())
+	if err != nil {
+		return nil, fmt.Errorf("unable to authenticate: %w", err)
+	}
+	retry := withToken(req, token)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	return t.base.RoundTrip(retry)
+}
+
+// tokenSource returns the tokens of the Grafana user who sent the request, or those of the datasource
+func (t *authTransport) tokenSource(ctx context.Context) (*tokenSource, error) {
+	if t.users == nil {
+		return t.tokens, nil
+	}
+	identity := identityFromContext(ctx)
+	if identity == nil {
+		return nil, errMissingIdentity
+	}
+	return t.users.get(identity), nil
+}
+
+// withToken returns a copy of the request authorized by the token, RoundTrippers must not modify requests
+func withToken(req *http.Request, token *ariaToken) *http.Request {
+	authorized := req.Clone(req.Context())
+	authorized.Header.Set("Authorization", fmt.Sprintf("OpsToken %s", token.Value))
+	return authorized
+}
@@ -0,0 +1,182 @@
+package plugin
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"swisscom-vmwareariaoperations-datasource/pkg/models"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newExchange serves tokens for the bearer token of the request over TLS and counts the connections to it
+func newExchange(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	var connections atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		writeJSON(rw, http.StatusOK, map[string]interface{}{"access_token": "aria-" + req.Header.Get("Authorization"), "expires_in": 3600})
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server, &connections
+}
+
+func exchangeSettings(url string) *models.PluginSettings {
+	return &models.PluginSettings{
+		Host:     "https://aria.example.com",
+		AuthMode: models.AuthModeForwardIdentity,
+		Secrets:  &models.SecretPluginSettings{TokenExchangeUrl: url},
+	}
+}
+
+func exchangeUsers(t *testing.T, config *models.PluginSettings, logins ...string) {
+	exchange, err := newExchangeClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := newUserTokenCache(config, nil, exchange)
+	for _, login := range logins {
+		token, err := users.get(&userIdentity{Login: login, Token: login}).Token(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.Value != "aria-Bearer "+login {
+			t.Errorf("expected the token of %s, got %q", login, token.Value)
+		}
+	}
+}
+
+// The exchange is trusted with the CA bundle of the datasource, the server name of Aria does not apply to it,
+// and all users share the connections of one client
+func TestExchangeClientUsesCABundle(t *testing.T) {
+	server, connections := newExchange(t)
+	config := exchangeSettings(server.URL)
+	config.TlsServerName = "aria.example.com"
+	config.Secrets.TlsCACert = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	exchangeUsers(t, config, "alice", "bob", "carol")
+	if connections.Load() != 1 {
+		t.Errorf("expected the users to share one connection, got %d", connections.Load())
+	}
+}
+
+// Like Aria, the exchange may only be reachable through the proxy
+func TestExchangeClientUsesProxy(t *testing.T) {
+	var hosts []string
+	// The proxy answers in place of the exchange behind it
+	proxy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		hosts = append(hosts, req.Host)
+		writeJSON(rw, http.StatusOK, map[string]interface{}{"access_token": "aria-" + req.Header.Get("Authorization"), "expires_in": 3600})
+	}))
+	t.Cleanup(proxy.Close)
+	config := exchangeSettings("http://vault.internal/v1/aria/token")
+	config.ProxyUrl = proxy.URL
+	exchangeUsers(t, config, "alice")
+	if len(hosts) != 1 || hosts[0] != "vault.internal" {
+		t.Errorf("expected the exchange to be requested through the proxy, got %v", hosts)
+	}
+}
+
+func TestTokenSourceRefreshesBeforeExpiry(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  *ariaToken
+		released bool
+		token    string
+		logins   int32
+		err      error
+	}{
+		{name: "no token", token: "new", logins: 1},
+		{name: "valid token", current: &ariaToken{Value: "current", ExpiresAt: time.Now().Add(time.Hour)}, token: "current"},
+		{name: "expires within the margin", current: &ariaToken{Value: "current", ExpiresAt: time.Now().Add(tokenRefreshMargin / 2)}, token: "new", logins: 1},
+		{name: "expired", current: &ariaToken{Value: "current", ExpiresAt: time.Now().Add(-time.Minute)}, token: "new", logins: 1},
+		{name: "released", released: true, err: errReleased},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logins atomic.Int32
+			s := &tokenSource{config: &models.PluginSettings{AuthMode: models.AuthModeToken, Secrets: &models.SecretPluginSettings{}}}
+			s.acquire = func(context.Context) (*ariaToken, error) {
+				logins.Add(1)
+				return &ariaToken{Value: "new", ExpiresAt: time.Now().Add(time.Hour)}, nil
+			}
+			s.token.Store(tt.current)
+			s.released.Store(tt.released)
+			token, err := s.Token(context.Background())
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if err == nil && token.Value != tt.token {
+				t.Errorf("expected token %s, got %s", tt.token, token.Value)
+			}
+			if logins.Load() != tt.logins {
+				t.Errorf("expected %d logins, got %d", tt.logins, logins.Load())
+			}
+		})
+	}
+}
+
+// A token rejected by Aria is replaced and the request is retried once with the new token
+func TestAuthTransportReauthenticatesOnce(t *testing.T) {
+	tests := []struct {
+		name     string
+		rejected []string
+		logins   int32
+		status   int
+	}{
+		{name: "valid token", logins: 1, status: http.StatusOK},
+		{name: "rejected token is replaced", rejected: []string{"token-1"}, logins: 2, status: http.StatusOK},
+		{name: "replaced token is not retried again", rejected: []string{"token-1", "token-2"}, logins: 2, status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aria := newFakeAria(t)
+			for _, token := range tt.rejected {
+				aria.rejected.Store("OpsToken "+token, true)
+			}
+			config := &models.PluginSettings{Host: aria.URL, AuthMode: models.AuthModePassword, Username: "admin", Secrets: &models.SecretPluginSettings{Password: "secret"}}
+			transport := &authTransport{base: http.DefaultTransport, tokens: newTokenSource(config, http.DefaultClient, nil)}
+			req, err := http.NewRequest(http.MethodGet, aria.URL+"/api/versions/current", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, resp.StatusCode)
+			}
+			if aria.logins.Load() != tt.logins {
+				t.Errorf("expected %d logins, got %d", tt.logins, aria.logins.Load())
+			}
+		})
+	}
+}
+
+func TestReleaseStopsLogins(t *testing.T) {
+	aria := newFakeAria(t)
+	config := &models.PluginSettings{Host: aria.URL, AuthMode: models.AuthModePassword, Username: "admin", Secrets: &models.SecretPluginSettings{Password: "secret"}}
+	tokens := newTokenSource(config, http.DefaultClient, nil)
+	if _, err := tokens.Token(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	tokens.Release()
+	if _, ok := aria.released.Load("OpsToken token-1"); !ok {
+		t.Error("expected the token to be released")
+	}
+	if _, err := tokens.Token(context.Background()); !errors.Is(err, errReleased) {
+		t.Errorf("expected released sources not to log in, got %v", err)
+	}
+	if aria.logins.Load() != 1 {
+		t.Errorf("expected a single login, got %d", aria.logins.Load())
+	}
+}
@@ -0,0 +1,241 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"swisscom-vmwareariaoperations-datasource/pkg/api"
+	"swisscom-vmwareariaoperations-datasource/pkg/models"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// Consecutive failed requests which open the circuit
+	breakerFailureThreshold = 5
+	// Time requests are short-circuited before Aria is probed again, doubled for every failed probe
+	breakerCoolDown    = 30 * time.Second
+	breakerMaxCoolDown = 5 * time.Minute
+)
+
+var shortCircuitedCounter = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "plugins",
+		Name:      "aria_short_circuited_requests_total",
+		Help:      "A counter for requests to Aria rejected while its circuit breaker is open",
+	},
+	[]string{"host"},
+)
+
+// Datasources connecting to the same Aria endpoint with the same settings share one breaker, so they stop
+// sending requests together
+var breakers sync.Map
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerProbing
+)
+
+// unavailableError is returned instead of sending requests to an Aria endpoint which is known to be down.
+// Err is the failure which opened the circuit.
+type unavailableError struct {
+	Since time.Time
+	Until time.Time
+	Err   error
+}
+
+func (e *unavailableError) Error() string {
+	message := fmt.Sprintf("Aria unavailable since %s, next attempt in %s", e.Since.Format(time.RFC3339), time.Until(e.Until).Round(time.Second))
+	if e.Err != nil {
+		message = fmt.Sprintf("%s: %v", message, e.Err)
+	}
+	return message
+}
+
+func (e *unavailableError) Unwrap() error {
+	return e.Err
+}
+
+// circuitBreaker stops requests to an Aria endpoint after repeated failures. Once the cool-down passed, one
+// request probes Aria with the version endpoint and closes the circuit again if it answers.
+type circuitBreaker struct {
+	host     string
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	since    time.Time
+	until    time.Time
+	coolDown time.Duration
+	// lastErr is the latest failure
+	lastErr error
+}
+
+// endpointBreaker returns the breaker of the Aria endpoint the datasource connects to. Breakers are not
+// shared across different connection settings, so a datasource with broken settings does not short-circuit
+// the requests of others.
+func endpointBreaker(config *models.PluginSettings) *circuitBreaker {
+	breaker, _ := breakers.LoadOrStore(breakerKey(config), &circuitBreaker{host: config.Host, coolDown: breakerCoolDown})
+	return breaker.(*circuitBreaker)
+}
+
+func breakerKey(config *models.PluginSettings) string {
+	proxyEnabled := config.ProxyOptions != nil && config.ProxyOptions.Enabled
+	settings := fmt.Sprintf("%s\x00%t\x00%s\x00%s\x00%t\x00%s\x00%s\x00%d\x00%d", config.Host, config.TlsSkipVerify, config.TlsServerName,
+		config.ProxyUrl, proxyEnabled, config.Secrets.TlsCACert, config.Secrets.TlsClientCert, config.ConnectTimeout, config.RequestTimeout)
+	sum := sha256.Sum256([]byte(settings))
+	return hex.EncodeToString(sum[:])
+}
+
+// allow reports whether a request may be sent and whether the caller has to probe Aria first.
+func (b *circuitBreaker) allow() (probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Now().Before(b.until) {
+			return false, &unavailableError{Since: b.since, Until: b.until, Err: b.lastErr}
+		}
+		b.state = breakerProbing
+		return true, nil
+	case breakerProbing:
+		return false, &unavailableError{Since: b.since, Until: b.until, Err: b.lastErr}
+	}
+	return false, nil
+}
+
+// record updates the breaker with the outcome of a request, a nil failure means Aria answered.
+func (b *circuitBreaker) record(failure error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if failure == nil {
+		if b.state != breakerClosed {
+			backend.Logger.Info("Aria available again", "host", b.host, "unavailableSince", b.since)
+		}
+		b.state, b.failures, b.coolDown, b.lastErr = breakerClosed, 0, breakerCoolDown, nil
+		return
+	}
+	b.failures++
+	b.lastErr = failure
+	switch {
+	case b.state == breakerProbing:
+		b.coolDown = min(2*b.coolDown, breakerMaxCoolDown)
+	case b.state == breakerClosed && b.failures >= breakerFailureThreshold:
+		b.since = time.Now()
+	default:
+		return
+	}
+	b.state = breakerOpen
+	b.until = time.Now().Add(b.coolDown)
+	backend.Logger.Warn("Aria unavailable, short-circuiting requests", "host", b.host, "failures", b.failures, "since", b.since, "until", b.until)
+}
+
+// breakerTransport sends requests through the circuit breaker of the Aria endpoint.
+type breakerTransport struct {
+	base    http.RoundTripper
+	breaker *circuitBreaker
+	// timeout bounds every request and the probe. It is enforced here instead of by the client, which
+	// would cancel the context of the request, so a hung Aria can be told apart from cancelled requests.
+	timeout time.Duration
+}
+
+func newBreakerTransport(base http.RoundTripper, config *models.PluginSettings, timeout time.Duration) *breakerTransport {
+	return &breakerTransport{base: base, breaker: endpointBreaker(config), timeout: timeout}
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	probe, err := t.breaker.allow()
+	if err != nil {
+		shortCircuitedCounter.WithLabelValues(t.breaker.host).Inc()
+		return nil, err
+	}
+	if probe {
+		if err := t.probe(req.Context()); err != nil {
+			t.breaker.record(err)
+			return nil, err
+		}
+		t.breaker.record(nil)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	// Requests cancelled by Grafana and broken connection settings say nothing about the health of Aria,
+	// requests which timed out while Grafana was still waiting do
+	if req.Context().Err() == nil && !misconfigured(err) {
+		t.breaker.record(failure(resp, err))
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// The timeout also covers reading the body
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelBody releases the timeout of a request once its body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// probe checks whether Aria answers again
+func (t *breakerTransport) probe(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), t.timeout)
+	defer cancel()
+	c, err := api.NewClientWithResponses(t.breaker.host, api.WithHTTPClient(&http.Client{Transport: t.base}))
+	if err != nil {
+		return err
+	}
+	backend.Logger.Debug("Probing Aria", "host", t.breaker.host)
+	resp, err := c.GetCurrentVersionOfServerUsingGETWithResponse(ctx)
+	if err != nil {
+		return fmt.Errorf("Aria still unavailable: %w", err)
+	}
+	if err := failure(resp.HTTPResponse, nil); err != nil {
+		return fmt.Errorf("Aria still unavailable: %w", err)
+	}
+	return nil
+}
+
+// failure returns outcomes which mean Aria can not serve requests, other errors like a missing
+// resource do not count towards opening the circuit
+func failure(resp *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError && resp.StatusCode != http.StatusNotImplemented {
+		return fmt.Errorf("Aria answered %s", resp.Status)
+	}
+	return nil
+}
+
+// misconfigured reports errors caused by the TLS or proxy settings of the datasource, they fail every
+// request until the settings are fixed and are reported as such instead of opening the circuit
+func misconfigured(err error) bool {
+	if err == nil {
+		return false
+	}
+	if _, ok := tlsErrorMessage(err); ok {
+		return true
+	}
+	var header tls.RecordHeaderError
+	var opErr *net.OpError
+	return errors.As(err, &header) || errors.As(err, &opErr) && opErr.Op == "proxyconnect"
+}
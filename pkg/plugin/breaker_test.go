@@ -0,0 +1,228 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"swisscom-vmwareariaoperations-datasource/pkg/models"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeEndpoint answers requests with the current status after the current delay and counts requests to the
+// version endpoint
+type fakeEndpoint struct {
+	*httptest.Server
+	status   atomic.Int32
+	delay    atomic.Int64
+	requests atomic.Int32
+	probes   atomic.Int32
+}
+
+func newFakeEndpoint(t *testing.T, tls bool) *fakeEndpoint {
+	e := &fakeEndpoint{}
+	e.status.Store(http.StatusOK)
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/api/versions/current" {
+			e.probes.Add(1)
+		} else {
+			e.requests.Add(1)
+		}
+		select {
+		case <-time.After(time.Duration(e.delay.Load())):
+		case <-req.Context().Done():
+			return
+		}
+		rw.WriteHeader(int(e.status.Load()))
+	})
+	e.Server = httptest.NewUnstartedServer(handler)
+	// Failed handshakes are expected, the server does not need to log them
+	e.Config.ErrorLog = log.New(io.Discard, "", 0)
+	if tls {
+		e.StartTLS()
+	} else {
+		e.Start()
+	}
+	t.Cleanup(e.Close)
+	return e
+}
+
+func (e *fakeEndpoint) get(t *testing.T, transport http.RoundTripper) (*http.Response, error) {
+	return e.getWithContext(t, context.Background(), transport)
+}
+
+func (e *fakeEndpoint) getWithContext(t *testing.T, ctx context.Context, transport http.RoundTripper) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.URL+"/api/resources", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := transport.RoundTrip(req)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return resp, err
+}
+
+func (b *circuitBreaker) expire() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.until = time.Now().Add(-time.Millisecond)
+}
+
+func (b *circuitBreaker) current() (breakerState, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state, b.coolDown
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	endpoint := newFakeEndpoint(t, false)
+	transport := newBreakerTransport(http.DefaultTransport, &models.PluginSettings{Host: endpoint.URL, Secrets: &models.SecretPluginSettings{}}, time.Second)
+	breaker := transport.breaker
+
+	endpoint.status.Store(http.StatusServiceUnavailable)
+	for i := 0; i < breakerFailureThreshold; i++ {
+		if state, _ := breaker.current(); state != breakerClosed {
+			t.Fatalf("expected the circuit to stay closed after %d failures", i)
+		}
+		if _, err := endpoint.get(t, transport); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name string
+		// expire lets the cool-down pass before the request
+		expire bool
+		status int
+		err    bool
+		// shortCircuited requests are rejected without reaching Aria
+		shortCircuited bool
+		state          breakerState
+		coolDown       time.Duration
+		requests       int32
+		probes         int32
+	}{
+		{name: "open short-circuits", err: true, shortCircuited: true, state: breakerOpen, coolDown: breakerCoolDown, requests: 5},
+		{name: "failed probe reopens with longer cool-down", expire: true, status: http.StatusServiceUnavailable, err: true, state: breakerOpen, coolDown: 2 * breakerCoolDown, requests: 5, probes: 1},
+		{name: "still open", status: http.StatusOK, err: true, shortCircuited: true, state: breakerOpen, coolDown: 2 * breakerCoolDown, requests: 5, probes: 1},
+		{name: "successful probe closes", expire: true, status: http.StatusOK, state: breakerClosed, coolDown: breakerCoolDown, requests: 6, probes: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.status != 0 {
+				endpoint.status.Store(int32(tt.status))
+			}
+			if tt.expire {
+				breaker.expire()
+			}
+			_, err := endpoint.get(t, transport)
+			if (err != nil) != tt.err {
+				t.Errorf("expected error %v, got %v", tt.err, err)
+			}
+			var unavailable *unavailableError
+			if errors.As(err, &unavailable) != tt.shortCircuited {
+				t.Errorf("expected short-circuited %v, got %v", tt.shortCircuited, err)
+			}
+			if state, coolDown := breaker.current(); state != tt.state || coolDown != tt.coolDown {
+				t.Errorf("expected state %v with cool-down %v, got %v with %v", tt.state, tt.coolDown, state, coolDown)
+			}
+			if endpoint.requests.Load() != tt.requests || endpoint.probes.Load() != tt.probes {
+				t.Errorf("expected %d requests and %d probes, got %d and %d", tt.requests, tt.probes, endpoint.requests.Load(), endpoint.probes.Load())
+			}
+		})
+	}
+}
+
+// A hung Aria does not answer at all, requests which time out open the circuit while requests cancelled by
+// Grafana do not count
+func TestBreakerOpensOnTimeouts(t *testing.T) {
+	endpoint := newFakeEndpoint(t, false)
+	endpoint.delay.Store(int64(time.Minute))
+	transport := newBreakerTransport(http.DefaultTransport, &models.PluginSettings{Host: endpoint.URL, Secrets: &models.SecretPluginSettings{}}, 50*time.Millisecond)
+
+	for i := 0; i < 2*breakerFailureThreshold; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err := endpoint.getWithContext(t, ctx, transport)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected the request to be cancelled, got %v", err)
+		}
+	}
+	if state, _ := transport.breaker.current(); state != breakerClosed {
+		t.Fatalf("expected cancelled requests to keep the circuit closed, got %v", state)
+	}
+
+	for i := 0; i < breakerFailureThreshold; i++ {
+		if _, err := endpoint.get(t, transport); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected request %d to time out, got %v", i, err)
+		}
+	}
+	if state, _ := transport.breaker.current(); state != breakerOpen {
+		t.Fatalf("expected timeouts to open the circuit, got %v", state)
+	}
+	var unavailable *unavailableError
+	if _, err := endpoint.get(t, transport); !errors.As(err, &unavailable) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected requests to be short-circuited with the timeout as cause, got %v", err)
+	}
+}
+
+// Certificate errors are caused by the settings of the datasource and do not open the circuit
+func TestBreakerIgnoresTLSErrors(t *testing.T) {
+	endpoint := newFakeEndpoint(t, true)
+	transport := newBreakerTransport(http.DefaultTransport, &models.PluginSettings{Host: endpoint.URL, Secrets: &models.SecretPluginSettings{}}, time.Second)
+	var err error
+	for i := 0; i < 2*breakerFailureThreshold; i++ {
+		if _, err = endpoint.get(t, transport); err == nil {
+			t.Fatal("expected a certificate error")
+		}
+	}
+	if state, _ := transport.breaker.current(); state != breakerClosed {
+		t.Errorf("expected the circuit to stay closed, got %v", state)
+	}
+	message, ok := healthErrorMessage(err)
+	if !ok || !strings.Contains(message, "unknown authority") {
+		t.Errorf("expected the certificate error to be explained, got %q", message)
+	}
+}
+
+func TestHealthErrorMessageExplainsCause(t *testing.T) {
+	endpoint := newFakeEndpoint(t, true)
+	_, cause := endpoint.get(t, http.DefaultTransport)
+	err := &unavailableError{Since: time.Now(), Until: time.Now().Add(time.Minute), Err: cause}
+	if message, ok := healthErrorMessage(err); !ok || !strings.Contains(message, "unknown authority") {
+		t.Errorf("expected the certificate error to be explained, got %q", message)
+	}
+	err.Err = errors.New("connection refused")
+	if message, ok := healthErrorMessage(err); !ok || !strings.HasPrefix(message, "Aria unavailable since") {
+		t.Errorf("expected Aria to be reported unavailable, got %q", message)
+	}
+}
+
+func TestEndpointBreakerKey(t *testing.T) {
+	settings := func(host string, caCert string, proxyUrl string) *models.PluginSettings {
+		return &models.PluginSettings{Host: host, ProxyUrl: proxyUrl, Secrets: &models.SecretPluginSettings{TlsCACert: caCert}}
+	}
+	host := "https://aria-breaker-key.example.com"
+	tests := []struct {
+		name   string
+		other  *models.PluginSettings
+		shared bool
+	}{
+		{name: "same settings", other: settings(host, "ca", ""), shared: true},
+		{name: "other host", other: settings("https://other.example.com", "ca", "")},
+		{name: "other CA", other: settings(host, "other", "")},
+		{name: "other proxy", other: settings(host, "ca", "http://proxy:3128")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if shared := endpointBreaker(settings(host, "ca", "")) == endpointBreaker(tt.other); shared != tt.shared {
+				t.Errorf("expected shared %v", tt.shared)
+			}
+		})
+	}
+}
@@ -3,14 +3,20 @@ package plugin
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"swisscom-vmwareariaoperations-datasource/pkg/api"
 	"swisscom-vmwareariaoperations-datasource/pkg/models"
+	"sync/atomic"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/oapi-codegen/runtime/types"
+	"golang.org/x/sync/singleflight"
 )
 
 // Make sure Datasource implements required interfaces. This is important to do
@@ -30,6 +36,11 @@ func AriaDatasource(_ context.Context, _ backend.DataSourceInstanceSettings) (in
 	mux := http.NewServeMux()
 	mux.HandleFunc("/adapterkinds", datasource.fetchAdapterKinds)
 	mux.HandleFunc("/metricpropertytag", datasource.fetchMetricsProperties)
+	mux.HandleFunc("/supermetrics", datasource.fetchSuperMetricsList)
+	mux.HandleFunc("/alerts/action", datasource.modifyAlerts)
+	mux.HandleFunc("/alerts/note", datasource.addAlertNote)
+	mux.HandleFunc("/maintenance/start", datasource.startMaintenance)
+	mux.HandleFunc("/maintenance/end", datasource.endMaintenance)
 
 	datasource.resourceHandler = httpadapter.New(mux)
 	return datasource, nil
@@ -39,7 +50,16 @@ func AriaDatasource(_ context.Context, _ backend.DataSourceInstanceSettings) (in
 // its health and has streaming skills.
 type Datasource struct {
 	resourceHandler backend.CallResourceHandler
-	ariaClient      *api.ClientWithResponses
+	// ariaClient is created by the first request and shared by all concurrent requests afterwards
+	ariaClient atomic.Pointer[api.ClientWithResponses]
+	tokens     atomic.Pointer[tokenSource]
+	users      atomic.Pointer[userTokenCache]
+	settings   atomic.Pointer[models.PluginSettings]
+	logins     singleflight.Group
+	// disposed instances do not log in anymore
+	disposed atomic.Bool
+	// superMetricKeys caches the stat keys of super metrics by name
+	superMetricKeys superMetricKeyCache
 }
 
 // Dispose here tells plugin SDK that plugin wants to clean up resources when a new instance
@@ -47,6 +67,13 @@ type Datasource struct {
 // be disposed and a new one will be created using NewSampleDatasource factory function.
 func (d *Datasource) Dispose() {
 	// Clean up datasource instance resources.
+	d.disposed.Store(true)
+	if tokens := d.tokens.Load(); tokens != nil {
+		tokens.Release()
+	}
+	if users := d.users.Load(); users != nil {
+		users.Release()
+	}
 }
 
 // QueryData handles multiple queries and returns multiple responses.
@@ -58,16 +85,27 @@ func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataReques
 	response := backend.NewQueryDataResponse()
 
 	// Before starting query making sure client exists
-	if d.ariaClient == nil {
-		err := d.createClient(&req.PluginContext)
-		if err != nil {
-			return response, err
-		}
+	ctx = withIdentity(ctx, req.PluginContext.User, req)
+	if err := d.ensureClient(ctx, &req.PluginContext); err != nil {
+		return response, err
 	}
 
+	queryTimeout := timeout(d.settings.Load().QueryTimeout, defaultQueryTimeout)
 	// loop over queries and execute them individually.
 	for _, q := range req.Queries {
-		res := d.query(ctx, q)
+		// Queries of a cancelled refresh are not sent to Aria anymore
+		if err := ctx.Err(); err != nil {
+			backend.Logger.Debug("Query cancelled", "refId", q.RefID, "error", err)
+			response.Responses[q.RefID] = backend.ErrDataResponse(backend.StatusTimeout, fmt.Sprintf("query cancelled: %v", err))
+			continue
+		}
+		queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+		res := d.query(queryCtx, q)
+		// Parts of the query may have failed silently, a timed out query never returns partial data
+		if errors.Is(queryCtx.Err(), context.DeadlineExceeded) {
+			res = backend.ErrDataResponse(backend.StatusTimeout, fmt.Sprintf("query timed out after %s", queryTimeout))
+		}
+		cancel()
 
 		// save the response in a hashmap
 		// based on with RefID as identifier
@@ -87,25 +125,70 @@ func (d *Datasource) query(ctx context.Context, query backend.DataQuery) backend
 		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("json unmarshal: %v", err.Error()))
 	}
 
+	// Aggregation and forecast apply to metric and formula queries
+	if err := qm.BuilderOptions.Aggregation.validate(); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("invalid aggregation: %v", err.Error()))
+	}
+	if err := qm.BuilderOptions.Forecast.validate(); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("invalid forecast: %v", err.Error()))
+	}
+
+	// Query types which are not based on resource metrics are handled separately
+	switch qm.BuilderOptions.QueryType {
+	case Platform:
+		return d.platformHealthQuery(ctx, qm)
+	case Collector:
+		return d.collectorsQuery(ctx)
+	case Adapter:
+		return d.adapterInstancesQuery(ctx, qm)
+	case Maintenance:
+		return d.maintenanceQuery(ctx, qm, query.TimeRange.From, query.TimeRange.To)
+	case Formula:
+		return downsampleResponse(forecastResponse(aggregateResponse(d.formulaQuery(ctx, qm, query.TimeRange.From, query.TimeRange.To), qm), qm), qm, query.MaxDataPoints)
+	}
+
 	// Avoid processing with query if no metrics were selected by user
-	if qm.BuilderOptions.Functions.WithMetric == "" {
+	if qm.BuilderOptions.Functions.WithMetric == "" && qm.BuilderOptions.Functions.WithSuperMetric == "" && qm.BuilderOptions.Expression == "" {
 		backend.Logger.Error("No metrics specified in query")
 		return backend.DataResponse{}
 	}
 
+	for _, f := range qm.BuilderOptions.SeriesFunctions {
+		if err := f.validate(); err != nil {
+			return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("invalid series function: %v", err.Error()))
+		}
+	}
+	for _, shift := range qm.BuilderOptions.TimeShift {
+		if offset, err := gtime.ParseDuration(shift); err != nil || offset <= 0 {
+			return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("invalid time shift %q", shift))
+		}
+	}
+	if qm.BuilderOptions.Expression != "" {
+		if _, err := parseExpression(qm.BuilderOptions.Expression); err != nil {
+			return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("invalid expression: %v", err.Error()))
+		}
+	}
+
 	// We need to get resourceIDs to query metrics
 	resourceIds, err := d.fetchResources(ctx, qm)
 	if err != nil {
 		backend.Logger.Error("Unable to get resourceIds", "error", err)
-		return backend.DataResponse{}
+		return backend.ErrDataResponse(backend.StatusBadGateway, fmt.Sprintf("unable to fetch resources: %v", err.Error()))
 	}
 
 	// Retrieving metrics for resourceIDs
 	metrics, err := d.fetchMetrics(ctx, qm, &resourceIds, query.TimeRange.From, query.TimeRange.To)
+	if errors.Is(err, errNoMetrics) {
+		// The resources have no data for the range
+		backend.Logger.Debug("No metrics found", "refId", query.RefID)
+		return backend.DataResponse{}
+	}
 	if err != nil {
 		backend.Logger.Error("Unable to fetch metrics", "error", err)
-		return backend.DataResponse{}
+		return backend.ErrDataResponse(backend.StatusBadGateway, fmt.Sprintf("unable to fetch metrics: %v", err.Error()))
 	}
+	applySeriesFunctions(metrics, qm.BuilderOptions.SeriesFunctions)
+
 	var properties *[]api.InternalResourcePropertyContents
 	// Retrieving properties for resourceIDs
 	properties, err = d.fetchProperties(ctx, qm, &resourceIds, query.TimeRange.From, query.TimeRange.To)
@@ -117,13 +200,192 @@ func (d *Datasource) query(ctx context.Context, query backend.DataQuery) backend
 	// Grafana UI automatically detects frames structure and chooses what kind of visualisation to use
 	switch qm.BuilderOptions.QueryType {
 	case TimeSeries:
-		return *timeSeriesFrame(metrics, resourceIds, properties, qm)
+		return d.timeSeriesResponse(ctx, qm, query, metrics, resourceIds, properties)
 	case Table:
 		return *tableFrame(metrics, resourceIds, properties, qm)
 	default:
-		return *timeSeriesFrame(metrics, resourceIds, properties, qm)
+		return d.timeSeriesResponse(ctx, qm, query, metrics, resourceIds, properties)
+	}
+
+}
+
+// timeSeriesResponse builds the frames of a metric query together with the series of every time shift,
+// which are labelled with their offset
+func (d *Datasource) timeSeriesResponse(ctx context.Context, qm queryModel, query backend.DataQuery, metrics *[]api.StatsOfResource, resourceIds map[types.UUID]*api.ResourceKey, properties *[]api.InternalResourcePropertyContents) backend.DataResponse {
+	response := *timeSeriesFrame(metrics, resourceIds, properties, qm)
+	for _, shift := range qm.BuilderOptions.TimeShift {
+		offset, _ := gtime.ParseDuration(shift)
+		shifted, err := d.fetchShiftedMetrics(ctx, qm, &resourceIds, query.TimeRange.From, query.TimeRange.To, offset)
+		if err != nil {
+			// Resources may not have existed in the shifted range, which should not hide the current series
+			backend.Logger.Warn("Unable to fetch shifted metrics", "offset", shift, "error", err)
+			continue
+		}
+		applySeriesFunctions(shifted, qm.BuilderOptions.SeriesFunctions)
+		// Shifted series are labelled with the properties the resources had at that time
+		shiftedProperties, err := d.fetchShiftedProperties(ctx, qm, &resourceIds, query.TimeRange.From, query.TimeRange.To, offset)
+		if err != nil {
+			backend.Logger.Warn("Unable to fetch shifted properties", "offset", shift, "error", err)
+		}
+		for _, frame := range timeSeriesFrame(shifted, resourceIds, shiftedProperties, qm).Frames {
+			frame.Fields[1].Labels["offset"] = shift
+			response.Frames = append(response.Frames, frame)
+		}
+	}
+	return seriesResponse(response, qm, query)
+}
+
+// seriesResponse applies the expression, aggregation, forecast and downsampling of the query to time series frames
+func seriesResponse(response backend.DataResponse, qm queryModel, query backend.DataQuery) backend.DataResponse {
+	response = expressionResponse(response, qm, query.Interval)
+	response = aggregateResponse(response, qm)
+	response = forecastResponse(response, qm)
+	return downsampleResponse(response, qm, query.MaxDataPoints)
+}
+
+// aggregateResponse applies the aggregation of the query to time series frames
+func aggregateResponse(response backend.DataResponse, qm queryModel) backend.DataResponse {
+	if response.Error != nil || qm.BuilderOptions.Aggregation.Operator == "" {
+		return response
+	}
+	frames, err := aggregateFrames(response.Frames, qm.BuilderOptions.Aggregation)
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("invalid aggregation: %v", err.Error()))
+	}
+	response.Frames = frames
+	return response
+}
+
+// expressionResponse replaces time series frames by the result of the expression of the query
+func expressionResponse(response backend.DataResponse, qm queryModel, interval time.Duration) backend.DataResponse {
+	if response.Error != nil || qm.BuilderOptions.Expression == "" {
+		return response
+	}
+	frames, err := expressionFrames(response.Frames, qm.BuilderOptions.Expression, interval)
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("unable to evaluate expression: %v", err.Error()))
+	}
+	response.Frames = frames
+	return response
+}
+
+// forecastResponse appends the forecast of the query to time series frames
+func forecastResponse(response backend.DataResponse, qm queryModel) backend.DataResponse {
+	if response.Error != nil || qm.BuilderOptions.Forecast.Model == "" {
+		return response
+	}
+	frames, err := forecastFrames(response.Frames, qm.BuilderOptions.Forecast)
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("invalid forecast: %v", err.Error()))
+	}
+	response.Frames = frames
+	return response
+}
+
+// downsampleResponse limits time series frames to the points requested by Grafana unless disabled by the query
+func downsampleResponse(response backend.DataResponse, qm queryModel, maxDataPoints int64) backend.DataResponse {
+	if response.Error != nil || qm.BuilderOptions.DisableDownsampling || maxDataPoints <= 0 {
+		return response
+	}
+	response.Frames = downsampleFrames(response.Frames, int(maxDataPoints))
+	return response
+}
+
+func (d *Datasource) platformHealthQuery(ctx context.Context, qm queryModel) backend.DataResponse {
+	health, err := d.fetchPlatformHealth(ctx, qm.BuilderOptions.Filters.WhereService)
+	if err != nil {
+		backend.Logger.Error("Unable to fetch platform health", "error", err)
+		return backend.ErrDataResponse(backend.StatusBadGateway, fmt.Sprintf("unable to fetch platform health: %v", err.Error()))
+	}
+	return *platformHealthFrame(health)
+}
+
+func (d *Datasource) collectorsQuery(ctx context.Context) backend.DataResponse {
+	groups, err := d.fetchCollectorGroups(ctx)
+	if err != nil {
+		backend.Logger.Error("Unable to fetch collector groups", "error", err)
+		return backend.ErrDataResponse(backend.StatusBadGateway, fmt.Sprintf("unable to fetch collector groups: %v", err.Error()))
+	}
+	collectors, err := d.fetchCollectors(ctx, groups)
+	if err != nil {
+		backend.Logger.Error("Unable to fetch collectors", "error", err)
+		return backend.ErrDataResponse(backend.StatusBadGateway, fmt.Sprintf("unable to fetch collectors: %v", err.Error()))
+	}
+	return *collectorsFrame(collectors, groups)
+}
+
+func (d *Datasource) adapterInstancesQuery(ctx context.Context, qm queryModel) backend.DataResponse {
+	instances, err := d.fetchAdapterInstances(ctx, qm.BuilderOptions.Functions.AdapterKind, qm.BuilderOptions.Functions.AdapterInstanceId)
+	if err != nil {
+		backend.Logger.Error("Unable to fetch adapter instances", "error", err)
+		return backend.ErrDataResponse(backend.StatusBadGateway, fmt.Sprintf("unable to fetch adapter instances: %v", err.Error()))
+	}
+	// Collector names are only used for display, so the query does not fail without them
+	collectors, err := d.fetchCollectorList(ctx)
+	if err != nil {
+		backend.Logger.Warn("Unable to fetch collectors", "error", err)
 	}
+	return *adapterInstancesFrame(instances, collectors, time.Now())
+}
 
+func (d *Datasource) maintenanceQuery(ctx context.Context, qm queryModel, from time.Time, to time.Time) backend.DataResponse {
+	schedules, err := d.fetchMaintenanceSchedules(ctx)
+	if err != nil {
+		backend.Logger.Error("Unable to fetch maintenance schedules", "error", err)
+		return backend.ErrDataResponse(backend.StatusBadGateway, fmt.Sprintf("unable to fetch maintenance schedules: %v", err.Error()))
+	}
+	return *maintenanceFrame(schedules, from, to, qm)
+}
+
+// formulaQuery evaluates a super metric formula for every resource selected by the query. The result
+// has the same shape as a metric query, with the formula as metric name.
+func (d *Datasource) formulaQuery(ctx context.Context, qm queryModel, from time.Time, to time.Time) backend.DataResponse {
+	formula, err := parseFormula(qm.BuilderOptions.Formula)
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("invalid formula: %v", err.Error()))
+	}
+
+	resourceIds, err := d.fetchResources(ctx, qm)
+	if err != nil {
+		backend.Logger.Error("Unable to get resourceIds", "error", err)
+		return backend.ErrDataResponse(backend.StatusBadGateway, fmt.Sprintf("unable to fetch resources: %v", err.Error()))
+	}
+	fd, err := d.fetchFormulaData(ctx, formulaRefs(formula), resourceIds, from, to)
+	if err != nil {
+		backend.Logger.Error("Unable to fetch formula data", "error", err)
+		return backend.ErrDataResponse(backend.StatusBadGateway, fmt.Sprintf("unable to fetch formula data: %v", err.Error()))
+	}
+
+	metrics := make([]api.StatsOfResource, 0, len(resourceIds))
+	for resourceId := range resourceIds {
+		value, err := evaluateFormula(formula, resourceId, fd)
+		if err != nil {
+			return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("unable to evaluate formula: %v", err.Error()))
+		}
+		if !value.IsSeries {
+			value = seriesValue(series{to.UnixMilli(): value.Scalar})
+		}
+		timestamps, values := value.Series.sorted()
+		if len(timestamps) == 0 {
+			// The resource has no data for the range
+			continue
+		}
+		id := resourceId
+		metrics = append(metrics, api.StatsOfResource{
+			ResourceId: &id,
+			StatList: &api.StatList{Stat: &[]api.Stats{{
+				StatKey:    api.StatKey{Key: qm.BuilderOptions.Formula},
+				Timestamps: timestamps,
+				Data:       &values,
+			}}},
+		})
+	}
+
+	properties, err := d.fetchProperties(ctx, qm, &resourceIds, from, to)
+	if err != nil {
+		backend.Logger.Error("Unable to fetch properties", "error", err)
+	}
+	return *timeSeriesFrame(&metrics, resourceIds, properties, qm)
 }
 
 // CheckHealth handles health checks sent from Grafana to the plugin.
@@ -132,7 +394,7 @@ func (d *Datasource) query(ctx context.Context, query backend.DataQuery) backend
 // a datasource is working as expected.
 func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
 	res := &backend.CheckHealthResult{}
-	config, err := models.LoadPluginSettings(*req.PluginContext.DataSourceInstanceSettings)
+	config, err := loadSettings(ctx, &req.PluginContext)
 
 	if err != nil {
 		res.Status = backend.HealthStatusError
@@ -140,56 +402,74 @@ func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRe
 		return res, nil
 	}
 
-	if config.Secrets.Password == "" {
+	if config.Host == "" {
 		res.Status = backend.HealthStatusError
-		res.Message = "Password is missing"
+		res.Message = "Host is missing"
 		return res, nil
 	}
 
-	if config.Host == "" {
+	if err := validateAuth(config); err != nil {
 		res.Status = backend.HealthStatusError
-		res.Message = "Host is missing"
+		res.Message = err.Error()
 		return res, nil
 	}
 
-	if config.Username == "" {
+	if err := validateTLS(config); err != nil {
 		res.Status = backend.HealthStatusError
-		res.Message = "Username is missing"
+		res.Message = fmt.Sprintf("Invalid TLS settings: %s", err)
 		return res, nil
 	}
 
-	if config.AuthSource == "" {
+	if err := validateProxy(config); err != nil {
 		res.Status = backend.HealthStatusError
-		res.Message = "AuthSource is missing"
+		res.Message = fmt.Sprintf("Invalid proxy settings: %s", err)
 		return res, nil
 	}
 
-	if d.ariaClient == nil {
-		err := d.createClient(&req.PluginContext)
-		if err != nil {
-			return nil, err
+	ctx = withIdentity(ctx, req.PluginContext.User, req)
+	if err := d.ensureClient(ctx, &req.PluginContext); err != nil {
+		if message, ok := healthErrorMessage(err); ok {
+			res.Status = backend.HealthStatusError
+			res.Message = message
+			return res, nil
 		}
+		return nil, err
 	}
 	backend.Logger.Debug("Requesting vROPs version", "url", config.Host, "username", config.Username)
-	response, err := d.ariaClient.GetCurrentVersionOfServerUsingGETWithResponse(ctx)
+	response, err := d.ariaClient.Load().GetCurrentVersionOfServerUsingGETWithResponse(ctx)
 	if err != nil {
+		if message, ok := healthErrorMessage(err); ok {
+			res.Status = backend.HealthStatusError
+			res.Message = message
+			return res, nil
+		}
 		return nil, err
 	}
 	backend.Logger.Debug("Received vROPs version", "response", response.HTTPResponse.Body)
+	if response.JSON200 == nil {
+		res.Status = backend.HealthStatusError
+		res.Message = fmt.Sprintf("Unexpected response of Aria: %s", response.Status())
+		return res, nil
+	}
 
+	message := fmt.Sprintf("Successfull connection to %s", response.JSON200.ReleaseName)
+	if config.TlsSkipVerify {
+		message = fmt.Sprintf("%s, TLS verification is skipped", message)
+	}
+	if expiry := certificateExpiry(config, response.HTTPResponse.TLS); expiry != "" {
+		message = fmt.Sprintf("%s, %s", message, expiry)
+	}
 	return &backend.CheckHealthResult{
 		Status:  backend.HealthStatusOk,
-		Message: fmt.Sprintf("Successfull connection to %s", response.JSON200.ReleaseName),
+		Message: message,
 	}, nil
 }
 
 func (d *Datasource) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
 	// Before starting retrieving resources making sure client exists
-	if d.ariaClient == nil {
-		err := d.createClient(&req.PluginContext)
-		if err != nil {
-			return err
-		}
+	ctx = withIdentity(ctx, req.PluginContext.User, req)
+	if err := d.ensureClient(ctx, &req.PluginContext); err != nil {
+		return err
 	}
 	return d.resourceHandler.CallResource(ctx, req, sender)
 }
@@ -206,42 +486,91 @@ func NewRequestEditor(apiKey *string) func(ctx context.Context, req *http.Reques
 	}
 }
 
-func (d *Datasource) auth(config *models.PluginSettings) error {
-	hc := http.Client{}
-	headersFn := NewRequestEditor(config.Secrets.Token)
-	c, err := api.NewClientWithResponses(config.Host, api.WithHTTPClient(&hc), api.WithRequestEditorFn(headersFn))
+// auth acquires a new token for the user at the configured auth source
+func auth(ctx context.Context, config *models.PluginSettings, hc *http.Client, username string, password string) (*ariaToken, error) {
+	// The login authenticates with username and password, the pre-issued token is for other modes
+	headersFn := NewRequestEditor(nil)
+	c, err := api.NewClientWithResponses(config.Host, api.WithHTTPClient(hc), api.WithRequestEditorFn(headersFn))
 
 	if err != nil {
-		return err
+		return nil, err
 	}
-	backend.Logger.Debug("Getting token", "url", config.Host, "username", config.Username)
-	resp, err := c.AcquireTokenUsingPOSTWithResponse(context.TODO(), api.UsernamePassword{AuthSource: &config.AuthSource, Username: config.Username, Password: config.Secrets.Password})
+	backend.Logger.Debug("Getting token", "url", config.Host, "username", username)
+	resp, err := c.AcquireTokenUsingPOSTWithResponse(ctx, api.UsernamePassword{AuthSource: &config.AuthSource, Username: username, Password: password})
 	if err != nil {
 		backend.Logger.Debug("Failed to get token", "error", err)
-		return err
+		return nil, err
 	}
 	if resp.StatusCode() != http.StatusOK {
 		backend.Logger.Error("Expected HTTP 200", "StatusCode", resp.StatusCode(), "error", string(resp.Body))
 	}
 	if resp.JSON200 != nil && resp.JSON200.Token != "" {
-		config.Secrets.Token = &resp.JSON200.Token
-		return nil
+		// Validity is the expiry in epoch milliseconds
+		expiresAt := time.Now().Add(defaultTokenValidity)
+		if resp.JSON200.Validity > 0 {
+			expiresAt = time.UnixMilli(resp.JSON200.Validity)
+		}
+		backend.Logger.Debug("Received token", "expiresAt", expiresAt)
+		return &ariaToken{Value: resp.JSON200.Token, ExpiresAt: expiresAt}, nil
 	} else {
-		return fmt.Errorf("failed to authenticate: %s", string(resp.Body))
+		return nil, fmt.Errorf("failed to authenticate: %s", string(resp.Body))
 	}
 }
 
-func (d *Datasource) createClient(req *backend.PluginContext) error {
-	config, _ := models.LoadPluginSettings(*req.DataSourceInstanceSettings)
-	hc := http.Client{}
-	if config.Secrets.Token == nil {
-		err := d.auth(config)
+// ensureClient creates the client unless it exists, concurrent callers wait for a single login.
+func (d *Datasource) ensureClient(ctx context.Context, req *backend.PluginContext) error {
+	if d.ariaClient.Load() != nil {
+		return nil
+	}
+	// Like token refreshes, the shared login outlives the request which started it
+	login := context.WithoutCancel(ctx)
+	result := d.logins.DoChan("client", func() (interface{}, error) {
+		if d.ariaClient.Load() != nil {
+			return nil, nil
+		}
+		if d.disposed.Load() {
+			return nil, errReleased
+		}
+		return nil, d.createClient(login, req)
+	})
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case res := <-result:
+		return res.Err
+	}
+}
+
+func (d *Datasource) createClient(ctx context.Context, req *backend.PluginContext) error {
+	config, err := loadSettings(ctx, req)
+	if err != nil {
+		return fmt.Errorf("unable to load settings: %s", err)
+	}
+	hc, err := newHTTPClient(config)
+	if err != nil {
+		return err
+	}
+	var exchange *http.Client
+	if config.Secrets.TokenExchangeUrl != "" {
+		exchange, err = newExchangeClient(config)
 		if err != nil {
-			return fmt.Errorf("unable to authenticate: %s", err)
+			return err
 		}
 	}
-	c, err := api.NewClientWithResponses(config.Host, api.WithHTTPClient(&hc), api.WithRequestEditorFn(func(ctx context.Context, req *http.Request) error {
-		req.Header.Set("Authorization", fmt.Sprintf("OpsToken %s", *config.Secrets.Token))
+	transport := &authTransport{base: hc.Transport}
+	if config.AuthMode == models.AuthModeForwardIdentity {
+		// Every Grafana user authenticates on their own, there is no token of the datasource
+		transport.users = newUserTokenCache(config, hc, exchange)
+	} else {
+		transport.tokens = newTokenSource(config, hc, exchange)
+		// The first token is acquired right away, so wrong credentials are reported instead of failing requests
+		if _, err := transport.tokens.Token(ctx); err != nil {
+			return fmt.Errorf("unable to authenticate: %w", err)
+		}
+	}
+	// Data requests use the transport of the login with the token added
+	authenticated := &http.Client{Transport: transport}
+	c, err := api.NewClientWithResponses(config.Host, api.WithHTTPClient(authenticated), api.WithRequestEditorFn(func(ctx context.Context, req *http.Request) error {
 		req.Header.Set("content-type", "application/json")
 		req.Header.Set("accept", "application/json")
 		return nil
@@ -249,6 +578,13 @@ func (d *Datasource) createClient(req *backend.PluginContext) error {
 	if err != nil {
 		return fmt.Errorf("unable to create client: %s", err)
 	}
-	d.ariaClient = c
+	d.settings.Store(config)
+	d.tokens.Store(transport.tokens)
+	d.users.Store(transport.users)
+	d.ariaClient.Store(c)
+	// The first login may have finished after a concurrent Dispose, which did not see its tokens
+	if d.disposed.Load() {
+		d.Dispose()
+	}
 	return nil
 }
@@ -0,0 +1,248 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// fakeAria serves the endpoints used by the entry points and counts logins.
+type fakeAria struct {
+	*httptest.Server
+	logins atomic.Int32
+	// rejected tokens are answered with 401
+	rejected sync.Map
+	// released tokens were released by the datasource
+	released sync.Map
+	// cancelled counts resource queries which were cancelled while Aria did not answer
+	cancelled atomic.Int32
+}
+
+func newFakeAria(t *testing.T) *fakeAria {
+	f := &fakeAria{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/api/auth/token/acquire" {
+			// A slow login makes concurrent callers overlap
+			time.Sleep(20 * time.Millisecond)
+			login := f.logins.Add(1)
+			writeJSON(rw, http.StatusOK, map[string]interface{}{
+				"token":    fmt.Sprintf("token-%d", login),
+				"validity": time.Now().Add(time.Hour).UnixMilli(),
+			})
+			return
+		}
+		if _, ok := f.rejected.Load(req.Header.Get("Authorization")); ok {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch req.URL.Path {
+		case "/api/versions/current":
+			writeJSON(rw, http.StatusOK, map[string]interface{}{"releaseName": "fake"})
+		case "/api/maintenanceschedules":
+			writeJSON(rw, http.StatusOK, map[string]interface{}{"schedules": []interface{}{}})
+		case "/api/supermetrics":
+			writeJSON(rw, http.StatusOK, map[string]interface{}{"superMetrics": []interface{}{}})
+		case "/api/auth/token/release":
+			f.released.Store(req.Header.Get("Authorization"), true)
+			rw.WriteHeader(http.StatusOK)
+		case "/api/resources/query":
+			// Hangs like an overloaded Aria until the request is cancelled, which is only noticed once the
+			// body was read
+			_, _ = io.Copy(io.Discard, req.Body)
+			<-req.Context().Done()
+			f.cancelled.Add(1)
+		default:
+			t.Errorf("unexpected request %s", req.URL.Path)
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeAria) pluginContext(t *testing.T) backend.PluginContext {
+	// The rate limit is raised, so the tests measure concurrency and not throttling
+	settings, err := json.Marshal(map[string]interface{}{"host": f.URL, "username": "admin", "authSource": "local", "rateLimit": 10000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+		JSONData:                settings,
+		DecryptedSecureJSONData: map[string]string{"password": "secret"},
+	}}
+}
+
+func newTestDatasource(t *testing.T) *Datasource {
+	instance, err := AriaDatasource(context.Background(), backend.DataSourceInstanceSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return instance.(*Datasource)
+}
+
+// hammer calls QueryData, CheckHealth and CallResource concurrently.
+func hammer(t *testing.T, d *Datasource, pc backend.PluginContext, rounds int) {
+	query, err := json.Marshal(queryModel{BuilderOptions: QueryBuilderOptions{QueryType: Maintenance}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < rounds; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+				PluginContext: pc,
+				Queries:       []backend.DataQuery{{RefID: "A", JSON: query}},
+			})
+			if err != nil {
+				t.Errorf("QueryData: %v", err)
+				return
+			}
+			if resp.Responses["A"].Error != nil {
+				t.Errorf("QueryData response: %v", resp.Responses["A"].Error)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			res, err := d.CheckHealth(context.Background(), &backend.CheckHealthRequest{PluginContext: pc})
+			if err != nil {
+				t.Errorf("CheckHealth: %v", err)
+				return
+			}
+			if res.Status != backend.HealthStatusOk {
+				t.Errorf("CheckHealth status %v: %s", res.Status, res.Message)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			err := d.CallResource(context.Background(), &backend.CallResourceRequest{
+				PluginContext: pc,
+				Path:          "supermetrics",
+				Method:        http.MethodGet,
+				URL:           "supermetrics",
+			}, backend.CallResourceResponseSenderFunc(func(resp *backend.CallResourceResponse) error {
+				if resp.Status != http.StatusOK {
+					t.Errorf("CallResource status %d: %s", resp.Status, string(resp.Body))
+				}
+				return nil
+			}))
+			if err != nil {
+				t.Errorf("CallResource: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestConcurrentEntryPointsShareOneLogin(t *testing.T) {
+	aria := newFakeAria(t)
+	d := newTestDatasource(t)
+
+	hammer(t, d, aria.pluginContext(t), 50)
+
+	if logins := aria.logins.Load(); logins != 1 {
+		t.Fatalf("expected a single login, got %d", logins)
+	}
+}
+
+func TestConcurrentRequestsShareOneReauthentication(t *testing.T) {
+	aria := newFakeAria(t)
+	d := newTestDatasource(t)
+	pc := aria.pluginContext(t)
+
+	hammer(t, d, pc, 5)
+	// Aria forgets the token, every request in flight is rejected once
+	aria.rejected.Store("OpsToken token-1", true)
+	hammer(t, d, pc, 50)
+
+	if logins := aria.logins.Load(); logins != 2 {
+		t.Fatalf("expected one login and one reauthentication, got %d logins", logins)
+	}
+}
+
+// Queries in flight when the instance is disposed fail instead of logging in again, every token Aria still
+// knows is released
+func TestDisposeWhileQuerying(t *testing.T) {
+	aria := newFakeAria(t)
+	d := newTestDatasource(t)
+	pc := aria.pluginContext(t)
+	hammer(t, d, pc, 5)
+	query, err := json.Marshal(queryModel{BuilderOptions: QueryBuilderOptions{QueryType: Maintenance}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queryData := func() backend.DataResponse {
+		resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{PluginContext: pc, Queries: []backend.DataQuery{{RefID: "A", JSON: query}}})
+		if err != nil {
+			t.Errorf("QueryData: %v", err)
+			return backend.DataResponse{}
+		}
+		return resp.Responses["A"]
+	}
+
+	// Aria forgets the token, so the queries log in again while the instance is disposed
+	aria.rejected.Store("OpsToken token-1", true)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			queryData()
+		}()
+	}
+	d.Dispose()
+	wg.Wait()
+
+	logins := aria.logins.Load()
+	if res := queryData(); res.Error == nil || !strings.Contains(res.Error.Error(), errReleased.Error()) {
+		t.Errorf("expected queries of a disposed instance to fail, got %v", res.Error)
+	}
+	if aria.logins.Load() != logins {
+		t.Errorf("expected no login after Dispose, got %d logins instead of %d", aria.logins.Load(), logins)
+	}
+	for login := int32(2); login <= logins; login++ {
+		if _, ok := aria.released.Load(fmt.Sprintf("OpsToken token-%d", login)); !ok {
+			t.Errorf("expected token-%d to be released", login)
+		}
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	aria := newFakeAria(t)
+	pc := aria.pluginContext(t)
+	pc.DataSourceInstanceSettings.JSONData = []byte(fmt.Sprintf(`{"host":%q,"username":"admin","authSource":"local","queryTimeout":1}`, aria.URL))
+	d := newTestDatasource(t)
+	query := backend.DataQuery{RefID: "A", JSON: []byte(`{"builderOptions":{"queryType":"timeseries","functions":{"withMetric":"cpu|usage_average"}}}`)}
+
+	start := time.Now()
+	response, err := d.QueryData(context.Background(), &backend.QueryDataRequest{PluginContext: pc, Queries: []backend.DataQuery{query}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the query to end after its timeout, took %v", elapsed)
+	}
+	res := response.Responses["A"]
+	if res.Error == nil || res.Status != backend.StatusTimeout || !strings.Contains(res.Error.Error(), "timed out after 1s") {
+		t.Errorf("expected the query to time out, got %v %v", res.Status, res.Error)
+	}
+	// The request is cancelled with the query instead of keeping Aria busy
+	deadline := time.Now().Add(time.Second)
+	for aria.cancelled.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if aria.cancelled.Load() != 1 {
+		t.Errorf("expected the request to Aria to be cancelled, got %d", aria.cancelled.Load())
+	}
+}
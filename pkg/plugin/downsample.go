@@ -0,0 +1,95 @@
+package plugin
+
+import (
+	"math"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// downsampleFrames reduces every time series frame to at most maxPoints points with the Largest-Triangle-Three-Buckets
+// algorithm, which keeps peaks and the visual shape of a series. Other frames are returned unchanged.
+func downsampleFrames(frames data.Frames, maxPoints int) data.Frames {
+	// Fewer than three points can not keep both ends of a series
+	if maxPoints < 3 {
+		return frames
+	}
+	result := make(data.Frames, 0, len(frames))
+	reduced := 0
+	for _, frame := range frames {
+		if frame.Meta == nil || frame.Meta.Type != data.FrameTypeTimeSeriesMulti || frame.Rows() <= maxPoints {
+			result = append(result, frame)
+			continue
+		}
+		labels, s, ok := frameSeries(frame)
+		if !ok {
+			result = append(result, frame)
+			continue
+		}
+		timestamps, values := s.sorted()
+		timestamps, values = lttb(timestamps, values, maxPoints)
+		downsampled := make(series, len(timestamps))
+		for i, ts := range timestamps {
+			downsampled[ts] = values[i]
+		}
+		result = append(result, seriesFrame(frame.Fields[1].Name, labels, downsampled))
+		reduced++
+	}
+	backend.Logger.Debug("Downsampled series", "maxDataPoints", maxPoints, "series", len(frames), "reduced", reduced)
+	return result
+}
+
+// lttb selects threshold points of a sorted series. The first and last points are kept, from every bucket
+// in between the point forming the largest triangle with the previously selected point and the average
+// of the next bucket is chosen.
+func lttb(timestamps []int64, values []float64, threshold int) ([]int64, []float64) {
+	if threshold >= len(timestamps) || threshold < 3 {
+		return timestamps, values
+	}
+	sampledTimestamps := make([]int64, 0, threshold)
+	sampledValues := make([]float64, 0, threshold)
+	sampledTimestamps = append(sampledTimestamps, timestamps[0])
+	sampledValues = append(sampledValues, values[0])
+
+	bucketSize := float64(len(timestamps)-2) / float64(threshold-2)
+	selected := 0
+	for bucket := 0; bucket < threshold-2; bucket++ {
+		start := int(float64(bucket)*bucketSize) + 1
+		end := int(float64(bucket+1)*bucketSize) + 1
+
+		// Average of the next bucket, the last point for the final bucket
+		nextStart, nextEnd := end, int(float64(bucket+2)*bucketSize)+1
+		if nextEnd > len(timestamps) {
+			nextEnd = len(timestamps)
+		}
+		var avgX, avgY float64
+		for i := nextStart; i < nextEnd; i++ {
+			avgX += float64(timestamps[i])
+			avgY += values[i]
+		}
+		if count := float64(nextEnd - nextStart); count > 0 {
+			avgX /= count
+			avgY /= count
+		} else {
+			avgX, avgY = float64(timestamps[len(timestamps)-1]), values[len(values)-1]
+		}
+
+		maxArea := -1.0
+		next := start
+		for i := start; i < end; i++ {
+			area := math.Abs((float64(timestamps[selected])-avgX)*(values[i]-values[selected]) -
+				(float64(timestamps[selected])-float64(timestamps[i]))*(avgY-values[selected]))
+			if area > maxArea {
+				maxArea = area
+				next = i
+			}
+		}
+		sampledTimestamps = append(sampledTimestamps, timestamps[next])
+		sampledValues = append(sampledValues, values[next])
+		selected = next
+	}
+
+	sampledTimestamps = append(sampledTimestamps, timestamps[len(timestamps)-1])
+	sampledValues = append(sampledValues, values[len(values)-1])
+	return sampledTimestamps, sampledValues
+}
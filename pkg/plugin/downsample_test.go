@@ -0,0 +1,98 @@
+package plugin
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestLttb(t *testing.T) {
+	line := func(n int) ([]int64, []float64) {
+		timestamps := make([]int64, n)
+		values := make([]float64, n)
+		for i := range timestamps {
+			timestamps[i] = int64(i) * 1000
+			values[i] = float64(i % 10)
+		}
+		return timestamps, values
+	}
+	peak := func() ([]int64, []float64) {
+		timestamps, values := line(100)
+		for i := range values {
+			values[i] = 1
+		}
+		values[42] = 100
+		return timestamps, values
+	}
+	tests := []struct {
+		name      string
+		series    func() ([]int64, []float64)
+		threshold int
+		length    int
+		// a timestamp which has to be kept
+		kept int64
+	}{
+		{name: "shorter than threshold", series: func() ([]int64, []float64) { return line(5) }, threshold: 10, length: 5, kept: 4000},
+		{name: "threshold below three", series: func() ([]int64, []float64) { return line(50) }, threshold: 2, length: 50, kept: 25000},
+		{name: "reduced to threshold", series: func() ([]int64, []float64) { return line(1000) }, threshold: 100, length: 100, kept: 999000},
+		{name: "peak is kept", series: peak, threshold: 10, length: 10, kept: 42000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timestamps, values := tt.series()
+			sampledTimestamps, sampledValues := lttb(timestamps, values, tt.threshold)
+			if len(sampledTimestamps) != tt.length || len(sampledValues) != tt.length {
+				t.Fatalf("expected %d points, got %d", tt.length, len(sampledTimestamps))
+			}
+			if sampledTimestamps[0] != timestamps[0] || sampledTimestamps[len(sampledTimestamps)-1] != timestamps[len(timestamps)-1] {
+				t.Errorf("expected the first and last points to be kept")
+			}
+			found := false
+			for i, ts := range sampledTimestamps {
+				if i > 0 && ts <= sampledTimestamps[i-1] {
+					t.Fatalf("timestamps are not increasing at %d", i)
+				}
+				found = found || ts == tt.kept
+			}
+			if !found {
+				t.Errorf("expected %d to be kept", tt.kept)
+			}
+		})
+	}
+}
+
+func TestDownsampleFrames(t *testing.T) {
+	s := make(series, 100)
+	for i := int64(0); i < 100; i++ {
+		s[i*1000] = math.Sin(float64(i))
+	}
+	long := seriesFrame("cpu", data.Labels{"resourceId": "vm1"}, s)
+	short := seriesFrame("cpu", data.Labels{"resourceId": "vm2"}, series{0: 1, 1000: 2})
+	table := data.NewFrame("table", data.NewField("value", nil, make([]float64, 100)))
+	tests := []struct {
+		name      string
+		maxPoints int
+		rows      []int
+	}{
+		{name: "reduced", maxPoints: 20, rows: []int{20, 2, 100}},
+		{name: "below three points unchanged", maxPoints: 2, rows: []int{100, 2, 100}},
+		{name: "enough points unchanged", maxPoints: 1000, rows: []int{100, 2, 100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := downsampleFrames(data.Frames{long, short, table}, tt.maxPoints)
+			if len(result) != len(tt.rows) {
+				t.Fatalf("expected %d frames, got %d", len(tt.rows), len(result))
+			}
+			for i, frame := range result {
+				if frame.Rows() != tt.rows[i] {
+					t.Errorf("frame %d: expected %d rows, got %d", i, tt.rows[i], frame.Rows())
+				}
+			}
+			if labels := result[0].Fields[1].Labels; labels["resourceId"] != "vm1" {
+				t.Errorf("expected labels to be kept, got %v", labels)
+			}
+		})
+	}
+}
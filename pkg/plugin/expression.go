@@ -0,0 +1,570 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// This file implements arithmetic between the series of one query, e.g. mem|consumed / mem|guest_provisioned * 100.
+// Series are matched like PromQL vector matching: by all labels except __name__, or by the labels
+// given with on(...) or without the labels given with ignoring(...). group_left(...) and group_right(...)
+// allow many-to-one matching and copy the listed labels from the "one" side.
+
+type metricNode struct {
+	Name string
+}
+
+// vectorMatching holds the modifiers of a binary operation between two vectors
+type vectorMatching struct {
+	On      bool
+	Labels  []string
+	Group   string // left, right or empty for one-to-one matching
+	Include []string
+	// Bool makes comparisons return 0 or 1 instead of filtering
+	Bool bool
+}
+
+type vectorBinaryNode struct {
+	Op       string
+	Left     formulaNode
+	Right    formulaNode
+	Matching vectorMatching
+}
+
+// binary operators of expressions ordered by increasing precedence
+var expressionPrecedence = [][]string{
+	{"==", "!=", "<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+var comparisonOperators = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+// isMetricRune reports whether r can be part of an unquoted metric key or label name
+func isMetricRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '|' || r == ':' || r == '.'
+}
+
+func tokenizeExpression(expression string) ([]formulaToken, error) {
+	tokens := make([]formulaToken, 0)
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"':
+			// Quoted metric keys may contain any character, e.g. super metric keys
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, formulaToken{Kind: "string", Text: string(runes[i+1 : end]), Pos: i})
+			i = end + 1
+		case unicode.IsDigit(r) || r == '.':
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.' || runes[i] == 'e' || runes[i] == 'E' ||
+				((runes[i] == '+' || runes[i] == '-') && (runes[i-1] == 'e' || runes[i-1] == 'E'))) {
+				i++
+			}
+			v, err := strconv.ParseFloat(string(runes[start:i]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at position %d", string(runes[start:i]), start)
+			}
+			tokens = append(tokens, formulaToken{Kind: "number", Text: string(runes[start:i]), Value: v, Pos: start})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && isMetricRune(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, formulaToken{Kind: "ident", Text: string(runes[start:i]), Pos: start})
+		default:
+			op := string(r)
+			if i+1 < len(runes) {
+				switch two := string(runes[i : i+2]); two {
+				case "==", "!=", "<=", ">=":
+					op = two
+				}
+			}
+			if !strings.Contains("+-*/%()<>,", op) && len(op) == 1 {
+				return nil, fmt.Errorf("unexpected character %q at position %d", op, i)
+			}
+			tokens = append(tokens, formulaToken{Kind: "op", Text: op, Pos: i})
+			i += len(op)
+		}
+	}
+	tokens = append(tokens, formulaToken{Kind: "eof", Pos: len(runes)})
+	return tokens, nil
+}
+
+type expressionParser struct {
+	formulaParser
+}
+
+// parseExpression parses an expression between the metrics of a query.
+func parseExpression(expression string) (formulaNode, error) {
+	tokens, err := tokenizeExpression(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &expressionParser{formulaParser{tokens: tokens}}
+	node, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().Kind != "eof" {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.peek().Text, p.peek().Pos)
+	}
+	return node, nil
+}
+
+func (p *expressionParser) parseBinary(level int) (formulaNode, error) {
+	if level == len(expressionPrecedence) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp(expressionPrecedence[level]...) {
+		op := p.next().Text
+		matching, err := p.parseMatching(op)
+		if err != nil {
+			return nil, err
+		}
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = vectorBinaryNode{Op: op, Left: left, Right: right, Matching: matching}
+	}
+	return left, nil
+}
+
+// parseMatching parses the optional bool, on/ignoring and group_left/group_right modifiers after an operator
+func (p *expressionParser) parseMatching(op string) (vectorMatching, error) {
+	var matching vectorMatching
+	if p.isKeyword("bool") {
+		if !comparisonOperators[op] {
+			return matching, fmt.Errorf("bool modifier is only allowed for comparisons at position %d", p.peek().Pos)
+		}
+		p.next()
+		matching.Bool = true
+	}
+	if !p.isKeyword("on", "ignoring") {
+		return matching, nil
+	}
+	matching.On = strings.ToLower(p.next().Text) == "on"
+	labels, err := p.parseLabels()
+	if err != nil {
+		return matching, err
+	}
+	matching.Labels = labels
+	if p.isKeyword("group_left", "group_right") {
+		matching.Group = strings.TrimPrefix(strings.ToLower(p.next().Text), "group_")
+		if p.isOp("(") {
+			include, err := p.parseLabels()
+			if err != nil {
+				return matching, err
+			}
+			matching.Include = include
+		}
+	}
+	return matching, nil
+}
+
+// isKeyword reports whether the next token is one of the keywords, metrics with the same key have to be quoted
+func (p *expressionParser) isKeyword(keywords ...string) bool {
+	t := p.peek()
+	if t.Kind != "ident" {
+		return false
+	}
+	for _, keyword := range keywords {
+		if strings.EqualFold(t.Text, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *expressionParser) parseLabels() ([]string, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	labels := make([]string, 0)
+	for !p.isOp(")") {
+		t := p.next()
+		if t.Kind != "ident" && t.Kind != "string" {
+			return nil, fmt.Errorf("expected label name at position %d", t.Pos)
+		}
+		labels = append(labels, t.Text)
+		if !p.isOp(",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+func (p *expressionParser) parseUnary() (formulaNode, error) {
+	if p.isOp("-", "+") {
+		op := p.next().Text
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if op == "+" {
+			return x, nil
+		}
+		return unaryNode{Op: op, X: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *expressionParser) parsePrimary() (formulaNode, error) {
+	t := p.next()
+	switch t.Kind {
+	case "number":
+		return numberNode{Value: t.Value}, nil
+	case "string":
+		return metricNode{Name: t.Text}, nil
+	case "ident":
+		name := strings.ToLower(t.Text)
+		if singleFunctions[name] == nil || !p.isOp("(") {
+			return metricNode{Name: t.Text}, nil
+		}
+		p.next()
+		arg, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return callNode{Name: name, Args: []formulaNode{arg}}, nil
+	case "op":
+		if t.Text == "(" {
+			node, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return node, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", t.Text, t.Pos)
+}
+
+// expressionMetrics returns the metric keys used by an expression, invalid expressions use none
+func expressionMetrics(expression string) []string {
+	node, err := parseExpression(expression)
+	if err != nil {
+		return nil
+	}
+	metrics := make([]string, 0)
+	var walk func(n formulaNode)
+	walk = func(n formulaNode) {
+		switch n := n.(type) {
+		case metricNode:
+			metrics = append(metrics, n.Name)
+		case unaryNode:
+			walk(n.X)
+		case callNode:
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		case vectorBinaryNode:
+			walk(n.Left)
+			walk(n.Right)
+		}
+	}
+	walk(node)
+	return metrics
+}
+
+type vectorElement struct {
+	Labels data.Labels
+	Points series
+}
+
+// expressionValue is either a scalar or a vector of labelled series
+type expressionValue struct {
+	Scalar   float64
+	Vector   []vectorElement
+	IsVector bool
+}
+
+// evaluateExpression evaluates an expression on the series of a query grouped by metric key.
+func evaluateExpression(node formulaNode, metrics map[string][]vectorElement) (expressionValue, error) {
+	switch n := node.(type) {
+	case numberNode:
+		return expressionValue{Scalar: n.Value}, nil
+	case metricNode:
+		return expressionValue{Vector: metrics[n.Name], IsVector: true}, nil
+	case unaryNode:
+		x, err := evaluateExpression(n.X, metrics)
+		if err != nil {
+			return x, err
+		}
+		return mapExpression(x, func(v float64) float64 { return -v }), nil
+	case callNode:
+		x, err := evaluateExpression(n.Args[0], metrics)
+		if err != nil {
+			return x, err
+		}
+		return mapExpression(x, singleFunctions[n.Name]), nil
+	case vectorBinaryNode:
+		left, err := evaluateExpression(n.Left, metrics)
+		if err != nil {
+			return left, err
+		}
+		right, err := evaluateExpression(n.Right, metrics)
+		if err != nil {
+			return right, err
+		}
+		return evaluateVectorBinary(n, left, right)
+	}
+	return expressionValue{}, fmt.Errorf("unsupported expression %T", node)
+}
+
+// mapExpression applies fn to every value, the metric name is dropped as the result is a different quantity
+func mapExpression(x expressionValue, fn func(float64) float64) expressionValue {
+	if !x.IsVector {
+		return expressionValue{Scalar: fn(x.Scalar)}
+	}
+	result := make([]vectorElement, 0, len(x.Vector))
+	for _, e := range x.Vector {
+		points := make(series, len(e.Points))
+		for ts, v := range e.Points {
+			points[ts] = fn(v)
+		}
+		result = append(result, vectorElement{Labels: withoutLabels(e.Labels, "__name__"), Points: points})
+	}
+	return expressionValue{Vector: result, IsVector: true}
+}
+
+func evaluateVectorBinary(n vectorBinaryNode, left expressionValue, right expressionValue) (expressionValue, error) {
+	fn := binaryOperator(n.Op)
+	filter := comparisonOperators[n.Op] && !n.Matching.Bool
+	switch {
+	case !left.IsVector && !right.IsVector:
+		return expressionValue{Scalar: fn(left.Scalar, right.Scalar)}, nil
+	case !right.IsVector:
+		return expressionValue{Vector: combineScalar(left.Vector, func(v float64) (float64, bool) {
+			return comparedValue(fn(v, right.Scalar), v, filter)
+		}, filter), IsVector: true}, nil
+	case !left.IsVector:
+		return expressionValue{Vector: combineScalar(right.Vector, func(v float64) (float64, bool) {
+			return comparedValue(fn(left.Scalar, v), v, filter)
+		}, filter), IsVector: true}, nil
+	}
+
+	matching := n.Matching
+	// many is the side whose series are kept, one is the side matched at most once per signature
+	many, one := left.Vector, right.Vector
+	if matching.Group == "right" {
+		many, one = right.Vector, left.Vector
+	}
+	ones := make(map[string]vectorElement, len(one))
+	for _, e := range one {
+		signature := matchingSignature(e.Labels, matching)
+		if _, ok := ones[signature]; ok {
+			return expressionValue{}, fmt.Errorf("multiple series match %s on the %s side of %q, use group_left or group_right", signature, oppositeSide(matching.Group), n.Op)
+		}
+		ones[signature] = e
+	}
+	seen := make(map[string]bool, len(many))
+	result := make([]vectorElement, 0, len(many))
+	for _, m := range many {
+		signature := matchingSignature(m.Labels, matching)
+		o, ok := ones[signature]
+		if !ok {
+			continue
+		}
+		if matching.Group == "" {
+			if seen[signature] {
+				return expressionValue{}, fmt.Errorf("multiple series match %s on the %s side of %q, use group_left or group_right", signature, "left", n.Op)
+			}
+			seen[signature] = true
+		}
+		l, r := m, o
+		if matching.Group == "right" {
+			l, r = o, m
+		}
+		points := make(series)
+		for ts, lv := range l.Points {
+			rv, ok := r.Points[ts]
+			if !ok {
+				continue
+			}
+			if v, keep := comparedValue(fn(lv, rv), lv, filter); keep {
+				points[ts] = v
+			}
+		}
+		if len(points) > 0 {
+			result = append(result, vectorElement{Labels: resultLabels(m.Labels, o.Labels, matching, filter), Points: points})
+		}
+	}
+	return expressionValue{Vector: result, IsVector: true}, nil
+}
+
+// combineScalar applies fn to every value of a vector, fn reports whether a value is kept
+func combineScalar(vector []vectorElement, fn func(float64) (float64, bool), filter bool) []vectorElement {
+	result := make([]vectorElement, 0, len(vector))
+	for _, e := range vector {
+		points := make(series, len(e.Points))
+		for ts, v := range e.Points {
+			if value, keep := fn(v); keep {
+				points[ts] = value
+			}
+		}
+		if len(points) == 0 {
+			continue
+		}
+		labels := e.Labels
+		if !filter {
+			labels = withoutLabels(labels, "__name__")
+		}
+		result = append(result, vectorElement{Labels: labels, Points: points})
+	}
+	return result
+}
+
+// comparedValue returns the result of an operation, filtering comparisons keep the original value when true
+func comparedValue(result float64, original float64, filter bool) (float64, bool) {
+	if !filter {
+		return result, true
+	}
+	return original, result == 1
+}
+
+func matchingSignature(labels data.Labels, matching vectorMatching) string {
+	if matching.On {
+		signature := make(data.Labels, len(matching.Labels))
+		for _, label := range matching.Labels {
+			signature[label] = labels[label]
+		}
+		return signature.String()
+	}
+	return withoutLabels(labels, append([]string{"__name__"}, matching.Labels...)...).String()
+}
+
+func resultLabels(many data.Labels, one data.Labels, matching vectorMatching, filter bool) data.Labels {
+	labels := many.Copy()
+	if !filter {
+		delete(labels, "__name__")
+	}
+	if matching.Group == "" {
+		if matching.On {
+			labels = make(data.Labels, len(matching.Labels))
+			for _, label := range matching.Labels {
+				labels[label] = many[label]
+			}
+		} else {
+			labels = withoutLabels(labels, matching.Labels...)
+		}
+	}
+	for _, label := range matching.Include {
+		if v, ok := one[label]; ok && v != "" {
+			labels[label] = v
+		} else {
+			delete(labels, label)
+		}
+	}
+	return labels
+}
+
+func withoutLabels(labels data.Labels, names ...string) data.Labels {
+	result := labels.Copy()
+	for _, name := range names {
+		delete(result, name)
+	}
+	return result
+}
+
+func oppositeSide(group string) string {
+	if group == "right" {
+		return "left"
+	}
+	return "right"
+}
+
+// expressionFrames evaluates an expression on time series frames, the result replaces the frames of the query
+func expressionFrames(frames data.Frames, expression string, step time.Duration) (data.Frames, error) {
+	node, err := parseExpression(expression)
+	if err != nil {
+		return nil, err
+	}
+	if step < collectionInterval {
+		step = collectionInterval
+	}
+
+	// Changing properties split a series into several frames with different labels, frames with equal
+	// labels are merged before the series of different metrics are aligned to common timestamps
+	elements := make(map[string]*vectorElement)
+	for _, frame := range frames {
+		labels, s, ok := frameSeries(frame)
+		if !ok {
+			continue
+		}
+		key := labels.String()
+		e, ok := elements[key]
+		if !ok {
+			e = &vectorElement{Labels: labels.Copy(), Points: make(series, len(s))}
+			elements[key] = e
+		}
+		for ts, v := range s {
+			e.Points[ts] = v
+		}
+	}
+	metrics := make(map[string][]vectorElement)
+	for _, e := range elements {
+		name := e.Labels["__name__"]
+		metrics[name] = append(metrics[name], vectorElement{Labels: e.Labels, Points: e.Points.aligned(step)})
+	}
+
+	value, err := evaluateExpression(node, metrics)
+	if err != nil {
+		return nil, err
+	}
+	if !value.IsVector {
+		return nil, fmt.Errorf("expression does not reference any metric")
+	}
+	sort.Slice(value.Vector, func(i, j int) bool {
+		return value.Vector[i].Labels.String() < value.Vector[j].Labels.String()
+	})
+	result := make(data.Frames, 0, len(value.Vector))
+	for _, e := range value.Vector {
+		name := e.Labels["__name__"]
+		if name == "" {
+			name = expression
+			e.Labels["__name__"] = name
+		}
+		// Divisions by zero are dropped like missing values
+		points := make(series, len(e.Points))
+		for ts, v := range e.Points {
+			if !math.IsInf(v, 0) {
+				points[ts] = v
+			}
+		}
+		result = append(result, seriesFrame(name, e.Labels, points))
+	}
+	backend.Logger.Debug("Evaluated expression", "expression", expression, "series", len(frames), "result", len(result))
+	return result, nil
+}
@@ -0,0 +1,171 @@
+package plugin
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestParseExpressionErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		err        string
+	}{
+		{expression: "", err: "unexpected"},
+		{expression: "cpu +", err: "unexpected"},
+		{expression: "(cpu + mem", err: `expected ")"`},
+		{expression: `"cpu|usage`, err: "unterminated string"},
+		{expression: "cpu & mem", err: "unexpected character"},
+		{expression: "cpu + bool mem", err: "bool modifier is only allowed for comparisons"},
+		{expression: "cpu / on(host mem", err: `expected ")"`},
+		{expression: "cpu / on(1) mem", err: "expected label name"},
+		{expression: "sqrt(cpu", err: `expected ")"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			_, err := parseExpression(tt.expression)
+			if err == nil {
+				t.Fatalf("expected an error containing %q", tt.err)
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected an error containing %q, got %q", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestExpressionMetrics(t *testing.T) {
+	tests := []struct {
+		expression string
+		metrics    []string
+	}{
+		{expression: "mem|consumed / mem|guest_provisioned * 100", metrics: []string{"mem|consumed", "mem|guest_provisioned"}},
+		{expression: `"sm_1234-abcd" + -abs(cpu|usage)`, metrics: []string{"sm_1234-abcd", "cpu|usage"}},
+		{expression: `cpu / on("host") group_left(cluster) "on"`, metrics: []string{"cpu", "on"}},
+		{expression: "2 * 3", metrics: []string{}},
+		{expression: "cpu +", metrics: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			metrics := expressionMetrics(tt.expression)
+			if !slices.Equal(metrics, tt.metrics) {
+				t.Errorf("expected %v, got %v", tt.metrics, metrics)
+			}
+		})
+	}
+}
+
+func TestExpressionFrames(t *testing.T) {
+	const step = int64(300000)
+	frame := func(name string, labels data.Labels, values ...float64) *data.Frame {
+		s := make(series, len(values))
+		for i, v := range values {
+			s[int64(i)*step] = v
+		}
+		l := labels.Copy()
+		l["__name__"] = name
+		return seriesFrame(name, l, s)
+	}
+	vm1 := data.Labels{"resourceId": "vm1", "host": "h1"}
+	vm2 := data.Labels{"resourceId": "vm2", "host": "h1"}
+	host := data.Labels{"host": "h1", "cluster": "c1"}
+	frames := data.Frames{
+		frame("used", vm1, 1, 2),
+		frame("total", vm1, 4, 4),
+		frame("used", vm2, 3, 6),
+		frame("total", vm2, 6, 0),
+		frame("capacity", host, 10, 20),
+	}
+	tests := []struct {
+		name       string
+		expression string
+		// expected series by the labels of the result
+		expected map[string]series
+		err      string
+	}{
+		{
+			name:       "one to one, division by zero dropped",
+			expression: "used / total * 100",
+			expected: map[string]series{
+				"host=h1, resourceId=vm1": {0: 25, step: 50},
+				"host=h1, resourceId=vm2": {0: 50},
+			},
+		},
+		{
+			name:       "scalar arithmetic keeps precedence",
+			expression: "used + 2 * 3",
+			expected: map[string]series{
+				"host=h1, resourceId=vm1": {0: 7, step: 8},
+				"host=h1, resourceId=vm2": {0: 9, step: 12},
+			},
+		},
+		{
+			name:       "comparison filters and keeps the metric",
+			expression: "used > 2",
+			expected: map[string]series{
+				"__name__=used, host=h1, resourceId=vm2": {0: 3, step: 6},
+			},
+		},
+		{
+			name:       "bool comparison",
+			expression: "used > bool 2",
+			expected: map[string]series{
+				"host=h1, resourceId=vm1": {0: 0, step: 0},
+				"host=h1, resourceId=vm2": {0: 1, step: 1},
+			},
+		},
+		{
+			name:       "many to one with group_left",
+			expression: "used / on(host) group_left(cluster) capacity",
+			expected: map[string]series{
+				"cluster=c1, host=h1, resourceId=vm1": {0: 0.1, step: 0.1},
+				"cluster=c1, host=h1, resourceId=vm2": {0: 0.3, step: 0.3},
+			},
+		},
+		{
+			name:       "ignoring labels",
+			expression: "used - ignoring(resourceId) used",
+			err:        "multiple series match",
+		},
+		{
+			name:       "many to one without group modifier",
+			expression: "used / on(host) capacity",
+			err:        "multiple series match",
+		},
+		{
+			name:       "no metric",
+			expression: "1 + 1",
+			err:        "does not reference any metric",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := expressionFrames(frames, tt.expression, 0)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result) != len(tt.expected) {
+				t.Fatalf("expected %d frames, got %d", len(tt.expected), len(result))
+			}
+			for _, f := range result {
+				labels, s, _ := frameSeries(f)
+				if labels["__name__"] == tt.expression {
+					labels = withoutLabels(labels, "__name__")
+				}
+				expected, ok := tt.expected[labels.String()]
+				if !ok {
+					t.Fatalf("unexpected series %s", labels)
+				}
+				assertSeries(t, expected, s)
+			}
+		})
+	}
+}
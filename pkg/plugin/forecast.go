@@ -0,0 +1,388 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	ForecastLinear      = "linear"
+	ForecastHoltWinters = "holtWinters"
+	// Forecast outputs, the series with their predictions or a table with the time until each series
+	// is predicted to reach the threshold
+	ForecastOutputSeries    = "series"
+	ForecastOutputThreshold = "timeUntilThreshold"
+	// Predictions are limited to keep responses small when a long horizon is combined with fine grained data
+	maxForecastPoints = 5000
+	defaultConfidence = 0.95
+	defaultSeason     = 24 * time.Hour
+)
+
+// Holt-Winters smoothing factors tried when fitting a series, the combination with the smallest
+// one step ahead error is used
+var (
+	holtWintersAlphas = []float64{0.1, 0.3, 0.5, 0.7, 0.9}
+	holtWintersBetas  = []float64{0.01, 0.1, 0.3}
+	holtWintersGammas = []float64{0.05, 0.2, 0.5}
+)
+
+// validate reports configuration errors of a forecast, an empty model disables it.
+func (f Forecast) validate() error {
+	switch f.Model {
+	case "":
+		return nil
+	case ForecastLinear, ForecastHoltWinters:
+	default:
+		return fmt.Errorf("unsupported forecast model %q", f.Model)
+	}
+	horizon, err := gtime.ParseDuration(f.Horizon)
+	if err != nil {
+		return fmt.Errorf("invalid horizon: %v", err)
+	}
+	if horizon <= 0 {
+		return fmt.Errorf("horizon must be positive")
+	}
+	if f.Season != "" {
+		season, err := gtime.ParseDuration(f.Season)
+		if err != nil {
+			return fmt.Errorf("invalid season: %v", err)
+		}
+		if season <= 0 {
+			return fmt.Errorf("season must be positive")
+		}
+	}
+	if f.Confidence < 0 || f.Confidence >= 1 {
+		return fmt.Errorf("confidence must be between 0 and 1, got %v", f.Confidence)
+	}
+	switch f.Output {
+	case "", ForecastOutputSeries:
+	case ForecastOutputThreshold:
+		if f.Threshold == nil {
+			return fmt.Errorf("%s output needs a threshold", f.Output)
+		}
+	default:
+		return fmt.Errorf("unsupported forecast output %q", f.Output)
+	}
+	return nil
+}
+
+// forecastResult holds the predicted values and the bounds of the confidence interval
+type forecastResult struct {
+	Timestamps []int64
+	Predicted  []float64
+	Lower      []float64
+	Upper      []float64
+}
+
+// forecastFrames appends a predicted, lower and upper series for every series of the frames. With the
+// time until threshold output the frames are replaced by a table with the time until each series is
+// predicted to cross the threshold.
+func forecastFrames(frames data.Frames, forecast Forecast) (data.Frames, error) {
+	if forecast.Model == "" {
+		return frames, nil
+	}
+	if err := forecast.validate(); err != nil {
+		return nil, err
+	}
+	horizon, _ := gtime.ParseDuration(forecast.Horizon)
+	season := defaultSeason
+	if forecast.Season != "" {
+		season, _ = gtime.ParseDuration(forecast.Season)
+	}
+	confidence := forecast.Confidence
+	if confidence == 0 {
+		confidence = defaultConfidence
+	}
+	z := math.Sqrt2 * math.Erfinv(confidence)
+
+	// Changing properties split a resource into several frames, they are merged and keep the labels of
+	// the most recent frame
+	type group struct {
+		labels data.Labels
+		latest int64
+		points series
+	}
+	groups := make(map[string]*group)
+	for _, frame := range frames {
+		labels, s, ok := frameSeries(frame)
+		if !ok || len(s) == 0 {
+			continue
+		}
+		key := labels.String()
+		if resourceId, ok := labels["resourceId"]; ok {
+			key = fmt.Sprintf("%s|%s|%s", labels["__name__"], resourceId, labels["offset"])
+		}
+		g, ok := groups[key]
+		if !ok {
+			g = &group{points: make(series, len(s))}
+			groups[key] = g
+		}
+		for ts, v := range s {
+			g.points[ts] = v
+			if g.labels == nil || ts > g.latest {
+				g.latest = ts
+				g.labels = labels
+			}
+		}
+	}
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := append(data.Frames{}, frames...)
+	thresholds := data.NewFrame("timeUntilThreshold",
+		data.NewField("resourceId", nil, []string{}),
+		data.NewField("resourceName", nil, []string{}),
+		data.NewField("metric", nil, []string{}),
+		data.NewField("current", nil, []float64{}),
+		data.NewField("threshold", nil, []float64{}),
+		data.NewField("crossing", nil, []*time.Time{}),
+		data.NewField("timeUntilThreshold", nil, []*float64{}).SetConfig(&data.FieldConfig{Unit: "s"}),
+	).SetMeta(&data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+	})
+	for _, key := range keys {
+		g := groups[key]
+		timestamps, values := g.points.sorted()
+		if len(timestamps) < 3 {
+			backend.Logger.Debug("Not enough data to forecast", "series", g.labels.String(), "points", len(timestamps))
+			continue
+		}
+		step := medianInterval(timestamps)
+		timestamps, values = regularSeries(timestamps, values, step)
+		steps := int(horizon / step)
+		if steps > maxForecastPoints {
+			steps = maxForecastPoints
+		}
+		if steps < 1 {
+			steps = 1
+		}
+
+		var fc forecastResult
+		switch forecast.Model {
+		case ForecastLinear:
+			fc = linearForecast(timestamps, values, step, steps, z)
+		case ForecastHoltWinters:
+			fc = holtWintersForecast(timestamps, values, step, int(season/step), steps, z)
+		}
+		for kind, predicted := range map[string][]float64{"predicted": fc.Predicted, "lower": fc.Lower, "upper": fc.Upper} {
+			labels := g.labels.Copy()
+			labels["forecast"] = kind
+			s := make(series, len(predicted))
+			for i, v := range predicted {
+				s[fc.Timestamps[i]] = v
+			}
+			result = append(result, seriesFrame(labels["__name__"], labels, s))
+		}
+
+		if forecast.Output == ForecastOutputThreshold {
+			last, current := timestamps[len(timestamps)-1], values[len(values)-1]
+			var crossing *time.Time
+			var until *float64
+			if ts, ok := thresholdCrossing(last, current, fc, *forecast.Threshold); ok {
+				t := time.UnixMilli(ts)
+				seconds := float64(ts-last) / 1000
+				crossing, until = &t, &seconds
+			}
+			thresholds.AppendRow(g.labels["resourceId"], g.labels["resourceName"], g.labels["__name__"], current, *forecast.Threshold, crossing, until)
+		}
+	}
+	// Predicted frames are appended in map order, sorting keeps responses stable
+	sort.SliceStable(result[len(frames):], func(i, j int) bool {
+		return result[len(frames)+i].Fields[1].Labels.String() < result[len(frames)+j].Fields[1].Labels.String()
+	})
+	backend.Logger.Debug("Forecasted series", "model", forecast.Model, "horizon", forecast.Horizon, "series", len(groups))
+	if forecast.Output == ForecastOutputThreshold {
+		return data.Frames{thresholds}, nil
+	}
+	return result, nil
+}
+
+// regularSeries places the samples on a grid of the given step, missing samples repeat the previous value
+func regularSeries(timestamps []int64, values []float64, step time.Duration) ([]int64, []float64) {
+	stepMilli := step.Milliseconds()
+	start := timestamps[0]
+	count := int((timestamps[len(timestamps)-1]-start)/stepMilli) + 1
+	gridTimestamps := make([]int64, count)
+	gridValues := make([]float64, count)
+	j := 0
+	for i := range gridTimestamps {
+		gridTimestamps[i] = start + int64(i)*stepMilli
+		for j+1 < len(timestamps) && timestamps[j+1] <= gridTimestamps[i]+stepMilli/2 {
+			j++
+		}
+		gridValues[i] = values[j]
+	}
+	return gridTimestamps, gridValues
+}
+
+// linearForecast fits a least squares line and uses the prediction interval of the regression as bounds
+func linearForecast(timestamps []int64, values []float64, step time.Duration, steps int, z float64) forecastResult {
+	n := float64(len(values))
+	var meanX, meanY float64
+	for i := range values {
+		meanX += float64(timestamps[i]-timestamps[0]) / n
+		meanY += values[i] / n
+	}
+	var sxx, sxy float64
+	for i := range values {
+		dx := float64(timestamps[i]-timestamps[0]) - meanX
+		sxx += dx * dx
+		sxy += dx * (values[i] - meanY)
+	}
+	slope := 0.0
+	if sxx > 0 {
+		slope = sxy / sxx
+	}
+	intercept := meanY - slope*meanX
+	var sse float64
+	for i := range values {
+		residual := values[i] - (intercept + slope*float64(timestamps[i]-timestamps[0]))
+		sse += residual * residual
+	}
+	sigma := math.Sqrt(sse / (n - 2))
+
+	fc := newForecastResult(steps)
+	last := timestamps[len(timestamps)-1]
+	for h := 1; h <= steps; h++ {
+		ts := last + int64(h)*step.Milliseconds()
+		x := float64(ts - timestamps[0])
+		predicted := intercept + slope*x
+		margin := z * sigma * math.Sqrt(1+1/n+(x-meanX)*(x-meanX)/sxx)
+		fc.append(ts, predicted, margin)
+	}
+	return fc
+}
+
+// holtWintersForecast fits additive Holt-Winters smoothing. Series shorter than two seasons are fitted
+// without a seasonal component.
+func holtWintersForecast(timestamps []int64, values []float64, step time.Duration, seasonLength int, steps int, z float64) forecastResult {
+	if seasonLength < 2 || len(values) < 2*seasonLength {
+		seasonLength = 0
+	}
+	best := holtWintersFit{sse: math.Inf(1)}
+	gammas := holtWintersGammas
+	if seasonLength == 0 {
+		gammas = []float64{0}
+	}
+	for _, alpha := range holtWintersAlphas {
+		for _, beta := range holtWintersBetas {
+			for _, gamma := range gammas {
+				fit := fitHoltWinters(values, seasonLength, alpha, beta, gamma)
+				if fit.sse < best.sse {
+					best = fit
+				}
+			}
+		}
+	}
+	sigma2 := 0.0
+	if best.count > 0 {
+		sigma2 = best.sse / float64(best.count)
+	}
+
+	fc := newForecastResult(steps)
+	last := timestamps[len(timestamps)-1]
+	variance := 0.0
+	for h := 1; h <= steps; h++ {
+		predicted := best.level + float64(h)*best.trend
+		if seasonLength > 0 {
+			predicted += best.seasonal[len(best.seasonal)-seasonLength+(h-1)%seasonLength]
+		}
+		// Variance of the h step ahead error of additive Holt-Winters
+		if j := h - 1; j > 0 {
+			c := best.alpha * (1 + float64(j)*best.beta)
+			if seasonLength > 0 && j%seasonLength == 0 {
+				c += best.gamma
+			}
+			variance += c * c
+		}
+		fc.append(last+int64(h)*step.Milliseconds(), predicted, z*math.Sqrt(sigma2*(1+variance)))
+	}
+	return fc
+}
+
+type holtWintersFit struct {
+	alpha, beta, gamma float64
+	level, trend       float64
+	seasonal           []float64
+	sse                float64
+	count              int
+}
+
+func fitHoltWinters(values []float64, seasonLength int, alpha float64, beta float64, gamma float64) holtWintersFit {
+	fit := holtWintersFit{alpha: alpha, beta: beta, gamma: gamma}
+	start := 1
+	if seasonLength == 0 {
+		fit.level = values[0]
+		fit.trend = values[1] - values[0]
+	} else {
+		// The first two seasons initialize level, trend and seasonal indices
+		var first, second float64
+		for i := 0; i < seasonLength; i++ {
+			first += values[i] / float64(seasonLength)
+			second += values[seasonLength+i] / float64(seasonLength)
+		}
+		fit.level = first
+		fit.trend = (second - first) / float64(seasonLength)
+		fit.seasonal = make([]float64, seasonLength, len(values))
+		for i := 0; i < seasonLength; i++ {
+			fit.seasonal[i] = values[i] - first
+		}
+		start = seasonLength
+	}
+	for t := start; t < len(values); t++ {
+		seasonal := 0.0
+		if seasonLength > 0 {
+			seasonal = fit.seasonal[t-seasonLength]
+		}
+		err := values[t] - (fit.level + fit.trend + seasonal)
+		fit.sse += err * err
+		fit.count++
+		level := alpha*(values[t]-seasonal) + (1-alpha)*(fit.level+fit.trend)
+		fit.trend = beta*(level-fit.level) + (1-beta)*fit.trend
+		fit.level = level
+		if seasonLength > 0 {
+			fit.seasonal = append(fit.seasonal, gamma*(values[t]-level)+(1-gamma)*seasonal)
+		}
+	}
+	return fit
+}
+
+func newForecastResult(steps int) forecastResult {
+	return forecastResult{
+		Timestamps: make([]int64, 0, steps),
+		Predicted:  make([]float64, 0, steps),
+		Lower:      make([]float64, 0, steps),
+		Upper:      make([]float64, 0, steps),
+	}
+}
+
+func (fc *forecastResult) append(ts int64, predicted float64, margin float64) {
+	fc.Timestamps = append(fc.Timestamps, ts)
+	fc.Predicted = append(fc.Predicted, predicted)
+	fc.Lower = append(fc.Lower, predicted-margin)
+	fc.Upper = append(fc.Upper, predicted+margin)
+}
+
+// thresholdCrossing returns the first predicted time at which the series reaches the threshold, coming
+// from the side of the current value. A threshold equal to the current value is crossed at the last sample.
+func thresholdCrossing(last int64, current float64, fc forecastResult, threshold float64) (int64, bool) {
+	if current == threshold {
+		return last, true
+	}
+	rising := current < threshold
+	for i, v := range fc.Predicted {
+		if (rising && v >= threshold) || (!rising && v <= threshold) {
+			return fc.Timestamps[i], true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,164 @@
+package plugin
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestForecastValidate(t *testing.T) {
+	threshold := 90.0
+	tests := []struct {
+		forecast Forecast
+		valid    bool
+	}{
+		{forecast: Forecast{}, valid: true},
+		{forecast: Forecast{Model: ForecastLinear, Horizon: "7d"}, valid: true},
+		{forecast: Forecast{Model: ForecastHoltWinters, Horizon: "1d", Season: "1w", Confidence: 0.8}, valid: true},
+		{forecast: Forecast{Model: ForecastLinear, Horizon: "30d", Output: ForecastOutputThreshold, Threshold: &threshold}, valid: true},
+		{forecast: Forecast{Model: "arima", Horizon: "7d"}},
+		{forecast: Forecast{Model: ForecastLinear}},
+		{forecast: Forecast{Model: ForecastLinear, Horizon: "-1d"}},
+		{forecast: Forecast{Model: ForecastHoltWinters, Horizon: "1d", Season: "often"}},
+		{forecast: Forecast{Model: ForecastLinear, Horizon: "1d", Confidence: 1}},
+		{forecast: Forecast{Model: ForecastLinear, Horizon: "1d", Output: ForecastOutputThreshold}},
+		{forecast: Forecast{Model: ForecastLinear, Horizon: "1d", Output: "chart"}},
+	}
+	for _, tt := range tests {
+		if err := tt.forecast.validate(); (err == nil) != tt.valid {
+			t.Errorf("%+v: expected valid %v, got %v", tt.forecast, tt.valid, err)
+		}
+	}
+}
+
+func TestForecastModels(t *testing.T) {
+	const step = 5 * time.Minute
+	timestamps := func(n int) []int64 {
+		result := make([]int64, n)
+		for i := range result {
+			result[i] = int64(i) * step.Milliseconds()
+		}
+		return result
+	}
+	line := make([]float64, 12)
+	constant := make([]float64, 12)
+	seasonal := make([]float64, 12)
+	for i := range line {
+		line[i] = float64(i)
+		constant[i] = 5
+		seasonal[i] = float64(i%4 + 1)
+	}
+	z := math.Sqrt2 * math.Erfinv(defaultConfidence)
+	tests := []struct {
+		name     string
+		forecast func() forecastResult
+		expected []float64
+	}{
+		{
+			name:     "linear continues a line",
+			forecast: func() forecastResult { return linearForecast(timestamps(12), line, step, 3, z) },
+			expected: []float64{12, 13, 14},
+		},
+		{
+			name:     "holt-winters keeps a constant",
+			forecast: func() forecastResult { return holtWintersForecast(timestamps(12), constant, step, 0, 3, z) },
+			expected: []float64{5, 5, 5},
+		},
+		{
+			name:     "holt-winters repeats the season",
+			forecast: func() forecastResult { return holtWintersForecast(timestamps(12), seasonal, step, 4, 5, z) },
+			expected: []float64{1, 2, 3, 4, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := tt.forecast()
+			if len(fc.Predicted) != len(tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, fc.Predicted)
+			}
+			for i, expected := range tt.expected {
+				if math.Abs(fc.Predicted[i]-expected) > 1e-6 {
+					t.Errorf("step %d: expected %v, got %v", i+1, expected, fc.Predicted[i])
+				}
+				if fc.Lower[i] > fc.Predicted[i] || fc.Upper[i] < fc.Predicted[i] {
+					t.Errorf("step %d: %v is outside of [%v, %v]", i+1, fc.Predicted[i], fc.Lower[i], fc.Upper[i])
+				}
+				if ts := int64(11+i+1) * step.Milliseconds(); fc.Timestamps[i] != ts {
+					t.Errorf("step %d: expected timestamp %d, got %d", i+1, ts, fc.Timestamps[i])
+				}
+			}
+		})
+	}
+}
+
+func TestForecastFrames(t *testing.T) {
+	const step = int64(300000)
+	s := make(series, 10)
+	for i := int64(0); i < 10; i++ {
+		s[i*step] = float64(i)
+	}
+	frames := data.Frames{seriesFrame("cpu", data.Labels{"__name__": "cpu", "resourceId": "vm1", "resourceName": "vm-1"}, s)}
+	reached, missed := 12.0, 5.0
+
+	t.Run("series", func(t *testing.T) {
+		result, err := forecastFrames(frames, Forecast{Model: ForecastLinear, Horizon: "15m", Threshold: &reached})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result) != 4 {
+			t.Fatalf("expected the series and its predicted, lower and upper series, got %d frames", len(result))
+		}
+		for _, frame := range result {
+			if frame.Meta.Type != data.FrameTypeTimeSeriesMulti {
+				t.Errorf("expected only time series, got %s", frame.Meta.Type)
+			}
+		}
+	})
+
+	tests := []struct {
+		name      string
+		threshold float64
+		until     *float64
+	}{
+		{name: "threshold reached", threshold: reached, until: func() *float64 { v := 900.0; return &v }()},
+		{name: "threshold behind the trend", threshold: missed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := forecastFrames(frames, Forecast{Model: ForecastLinear, Horizon: "15m", Output: ForecastOutputThreshold, Threshold: &tt.threshold})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result) != 1 || result[0].Name != "timeUntilThreshold" {
+				t.Fatalf("expected the threshold table only, got %d frames", len(result))
+			}
+			table := result[0]
+			if table.Rows() != 1 {
+				t.Fatalf("expected one row, got %d", table.Rows())
+			}
+			until := table.Fields[6].At(0).(*float64)
+			switch {
+			case tt.until == nil && until != nil:
+				t.Errorf("expected no crossing, got %v", *until)
+			case tt.until != nil && (until == nil || math.Abs(*until-*tt.until) > 1e-6):
+				t.Errorf("expected crossing in %v, got %v", *tt.until, until)
+			}
+		})
+	}
+}
+
+func TestRegularSeries(t *testing.T) {
+	timestamps, values := regularSeries([]int64{0, 300000, 900000, 1210000}, []float64{1, 2, 4, 5}, 5*time.Minute)
+	expectedTimestamps := []int64{0, 300000, 600000, 900000, 1200000}
+	expectedValues := []float64{1, 2, 2, 4, 5}
+	if len(timestamps) != len(expectedTimestamps) {
+		t.Fatalf("expected %v, got %v", expectedTimestamps, timestamps)
+	}
+	for i := range timestamps {
+		if timestamps[i] != expectedTimestamps[i] || values[i] != expectedValues[i] {
+			t.Errorf("point %d: expected %d=%v, got %d=%v", i, expectedTimestamps[i], expectedValues[i], timestamps[i], values[i])
+		}
+	}
+}
@@ -0,0 +1,791 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/oapi-codegen/runtime/types"
+)
+
+// This file implements the subset of the Aria super metric formula language which can be evaluated on
+// data fetched by the plugin, e.g. avg(${adaptertype=VMWARE, objecttype=VirtualMachine, metric=cpu|usage_average, depth=1}).
+
+// series maps timestamps in epoch milliseconds to values
+type series map[int64]float64
+
+// Aria collects every resource at its own offset within the collection interval, so series of different
+// resources are aligned to the interval before they are combined
+const collectionInterval = 5 * time.Minute
+
+type formulaRef struct {
+	This         bool
+	AdapterKind  string
+	ResourceKind string
+	Name         string
+	Metric       string
+	Depth        int
+	Where        *formulaWhere
+}
+
+// formulaWhere is a where=($value <op> <number>) clause of a reference
+type formulaWhere struct {
+	Operand string
+	Value   float64
+}
+
+// relationKey identifies resources related to "this" resource in the same way
+func (r formulaRef) relationKey() string {
+	return fmt.Sprintf("%s|%s|%d", r.AdapterKind, r.ResourceKind, r.Depth)
+}
+
+type formulaNode interface{}
+
+type numberNode struct {
+	Value float64
+}
+
+type refNode struct {
+	Ref formulaRef
+}
+
+type unaryNode struct {
+	Op string
+	X  formulaNode
+}
+
+type binaryNode struct {
+	Op    string
+	Left  formulaNode
+	Right formulaNode
+}
+
+type ternaryNode struct {
+	Cond formulaNode
+	Then formulaNode
+	Else formulaNode
+}
+
+type callNode struct {
+	Name string
+	Args []formulaNode
+}
+
+var loopingFunctions = map[string]bool{"avg": true, "sum": true, "min": true, "max": true, "count": true}
+
+var singleFunctions = map[string]func(float64) float64{
+	"abs":   math.Abs,
+	"acos":  math.Acos,
+	"asin":  math.Asin,
+	"atan":  math.Atan,
+	"ceil":  math.Ceil,
+	"cos":   math.Cos,
+	"cosh":  math.Cosh,
+	"exp":   math.Exp,
+	"floor": math.Floor,
+	"log":   math.Log,
+	"log10": math.Log10,
+	"sin":   math.Sin,
+	"sinh":  math.Sinh,
+	"sqrt":  math.Sqrt,
+	"tan":   math.Tan,
+	"tanh":  math.Tanh,
+}
+
+type formulaToken struct {
+	Kind  string // number, ident, ref, op, eof
+	Text  string
+	Value float64
+	Pos   int
+}
+
+func tokenizeFormula(formula string) ([]formulaToken, error) {
+	tokens := make([]formulaToken, 0)
+	runes := []rune(formula)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '$':
+			if i+1 >= len(runes) || runes[i+1] != '{' {
+				return nil, fmt.Errorf("expected '{' after '$' at position %d", i)
+			}
+			end := i + 2
+			for end < len(runes) && runes[end] != '}' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("unterminated reference at position %d", i)
+			}
+			tokens = append(tokens, formulaToken{Kind: "ref", Text: string(runes[i+2 : end]), Pos: i})
+			i = end + 1
+		case unicode.IsDigit(r) || r == '.':
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.' || runes[i] == 'e' || runes[i] == 'E' ||
+				((runes[i] == '+' || runes[i] == '-') && (runes[i-1] == 'e' || runes[i-1] == 'E'))) {
+				i++
+			}
+			v, err := strconv.ParseFloat(string(runes[start:i]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at position %d", string(runes[start:i]), start)
+			}
+			tokens = append(tokens, formulaToken{Kind: "number", Text: string(runes[start:i]), Value: v, Pos: start})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, formulaToken{Kind: "ident", Text: string(runes[start:i]), Pos: start})
+		default:
+			op := string(r)
+			if i+1 < len(runes) {
+				switch two := string(runes[i : i+2]); two {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					op = two
+				}
+			}
+			if !strings.Contains("+-*/%()?:,<>!", op) && len(op) == 1 {
+				return nil, fmt.Errorf("unexpected character %q at position %d", op, i)
+			}
+			tokens = append(tokens, formulaToken{Kind: "op", Text: op, Pos: i})
+			i += len(op)
+		}
+	}
+	tokens = append(tokens, formulaToken{Kind: "eof", Pos: len(runes)})
+	return tokens, nil
+}
+
+// parseFormulaRef parses the content of ${...}
+func parseFormulaRef(text string) (formulaRef, error) {
+	ref := formulaRef{Depth: 1}
+	for _, part := range splitTopLevel(text, ',') {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if strings.EqualFold(part, "this") {
+			ref.This = true
+			continue
+		}
+		key, value, found := strings.Cut(part, "=")
+		if !found {
+			return ref, fmt.Errorf("invalid reference attribute %q", part)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.Trim(strings.TrimSpace(value), "\"")
+		switch key {
+		case "adaptertype":
+			ref.AdapterKind = value
+		case "objecttype":
+			ref.ResourceKind = value
+		case "objectname", "resourcename":
+			ref.Name = value
+		case "metric", "attribute":
+			ref.Metric = value
+		case "depth":
+			depth, err := strconv.Atoi(value)
+			if err != nil || depth == 0 {
+				return ref, fmt.Errorf("invalid depth %q", value)
+			}
+			ref.Depth = depth
+		case "where":
+			where, err := parseFormulaWhere(value)
+			if err != nil {
+				return ref, err
+			}
+			ref.Where = where
+		default:
+			return ref, fmt.Errorf("unsupported reference attribute %q", key)
+		}
+	}
+	if ref.Metric == "" {
+		return ref, fmt.Errorf("reference ${%s} has no metric", text)
+	}
+	if !ref.This && ref.Name == "" && ref.ResourceKind == "" {
+		return ref, fmt.Errorf("reference ${%s} needs this, objectname or objecttype", text)
+	}
+	return ref, nil
+}
+
+func parseFormulaWhere(text string) (*formulaWhere, error) {
+	text = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(text), "("), ")"))
+	if !strings.HasPrefix(text, "$value") {
+		return nil, fmt.Errorf("only where=($value <operator> <number>) is supported, got %q", text)
+	}
+	rest := strings.TrimSpace(strings.TrimPrefix(text, "$value"))
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if strings.HasPrefix(rest, op) {
+			v, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimPrefix(rest, op)), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid where value in %q", text)
+			}
+			return &formulaWhere{Operand: op, Value: v}, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid where operator in %q", text)
+}
+
+func splitTopLevel(text string, sep rune) []string {
+	parts := make([]string, 0)
+	depth := 0
+	quoted := false
+	start := 0
+	for i, r := range text {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == '(' && !quoted:
+			depth++
+		case r == ')' && !quoted:
+			depth--
+		case r == sep && depth == 0 && !quoted:
+			parts = append(parts, text[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, text[start:])
+}
+
+type formulaParser struct {
+	tokens []formulaToken
+	pos    int
+}
+
+// parseFormula parses a super metric formula into an expression tree.
+func parseFormula(formula string) (formulaNode, error) {
+	tokens, err := tokenizeFormula(formula)
+	if err != nil {
+		return nil, err
+	}
+	p := &formulaParser{tokens: tokens}
+	node, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().Kind != "eof" {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.peek().Text, p.peek().Pos)
+	}
+	return node, nil
+}
+
+func (p *formulaParser) peek() formulaToken {
+	return p.tokens[p.pos]
+}
+
+func (p *formulaParser) next() formulaToken {
+	t := p.tokens[p.pos]
+	if t.Kind != "eof" {
+		p.pos++
+	}
+	return t
+}
+
+func (p *formulaParser) isOp(ops ...string) bool {
+	t := p.peek()
+	if t.Kind != "op" {
+		return false
+	}
+	for _, op := range ops {
+		if t.Text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *formulaParser) expect(op string) error {
+	if !p.isOp(op) {
+		return fmt.Errorf("expected %q at position %d", op, p.peek().Pos)
+	}
+	p.next()
+	return nil
+}
+
+func (p *formulaParser) parseTernary() (formulaNode, error) {
+	cond, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.isOp("?") {
+		return cond, nil
+	}
+	p.next()
+	then, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	return ternaryNode{Cond: cond, Then: then, Else: otherwise}, nil
+}
+
+// binary operators ordered by increasing precedence
+var formulaPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *formulaParser) parseBinary(level int) (formulaNode, error) {
+	if level == len(formulaPrecedence) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp(formulaPrecedence[level]...) {
+		op := p.next().Text
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{Op: op, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *formulaParser) parseUnary() (formulaNode, error) {
+	if p.isOp("-", "!", "+") {
+		op := p.next().Text
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if op == "+" {
+			return x, nil
+		}
+		return unaryNode{Op: op, X: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *formulaParser) parsePrimary() (formulaNode, error) {
+	t := p.next()
+	switch t.Kind {
+	case "number":
+		return numberNode{Value: t.Value}, nil
+	case "ref":
+		ref, err := parseFormulaRef(t.Text)
+		if err != nil {
+			return nil, err
+		}
+		return refNode{Ref: ref}, nil
+	case "ident":
+		name := strings.ToLower(t.Text)
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		args := make([]formulaNode, 0)
+		for !p.isOp(")") {
+			arg, err := p.parseTernary()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if !p.isOp(",") {
+				break
+			}
+			p.next()
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		if err := validateFormulaCall(name, args); err != nil {
+			return nil, err
+		}
+		return callNode{Name: name, Args: args}, nil
+	case "op":
+		if t.Text == "(" {
+			node, err := p.parseTernary()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return node, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", t.Text, t.Pos)
+}
+
+func validateFormulaCall(name string, args []formulaNode) error {
+	switch {
+	case loopingFunctions[name]:
+		if len(args) == 0 {
+			return fmt.Errorf("%s needs at least one argument", name)
+		}
+		if len(args) > 1 && name != "min" && name != "max" {
+			return fmt.Errorf("%s takes one argument", name)
+		}
+	case name == "pow":
+		if len(args) != 2 {
+			return fmt.Errorf("pow takes two arguments")
+		}
+	case singleFunctions[name] != nil:
+		if len(args) != 1 {
+			return fmt.Errorf("%s takes one argument", name)
+		}
+	default:
+		return fmt.Errorf("unsupported function %q", name)
+	}
+	return nil
+}
+
+// formulaRefs returns all references used in a formula
+func formulaRefs(node formulaNode) []formulaRef {
+	refs := make([]formulaRef, 0)
+	var walk func(n formulaNode)
+	walk = func(n formulaNode) {
+		switch n := n.(type) {
+		case refNode:
+			refs = append(refs, n.Ref)
+		case unaryNode:
+			walk(n.X)
+		case binaryNode:
+			walk(n.Left)
+			walk(n.Right)
+		case ternaryNode:
+			walk(n.Cond)
+			walk(n.Then)
+			walk(n.Else)
+		case callNode:
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		}
+	}
+	walk(node)
+	return refs
+}
+
+// formulaData holds everything needed to evaluate a formula for a set of resources
+type formulaData struct {
+	// Stats of every resource by metric key
+	Stats map[types.UUID]map[string]series
+	// Related resources of every "this" resource by relation key
+	Related map[string]map[types.UUID][]types.UUID
+	// Named resources by name
+	Named map[string][]types.UUID
+}
+
+// formulaValue is either a scalar or a time series
+type formulaValue struct {
+	Scalar   float64
+	Series   series
+	IsSeries bool
+}
+
+func scalarValue(v float64) formulaValue {
+	return formulaValue{Scalar: v}
+}
+
+func seriesValue(s series) formulaValue {
+	return formulaValue{Series: s, IsSeries: true}
+}
+
+// evaluateFormula evaluates a parsed formula in the context of one resource.
+func evaluateFormula(node formulaNode, this types.UUID, fd *formulaData) (formulaValue, error) {
+	switch n := node.(type) {
+	case numberNode:
+		return scalarValue(n.Value), nil
+	case refNode:
+		members := fd.members(n.Ref, this)
+		switch len(members) {
+		case 0:
+			// Resources without samples of the metric have no value, they do not fail the formula of others
+			return seriesValue(series{}), nil
+		case 1:
+			return seriesValue(members[0]), nil
+		}
+		return formulaValue{}, fmt.Errorf("reference to %s resolves to %d resources, use a looping function", n.Ref.Metric, len(members))
+	case unaryNode:
+		x, err := evaluateFormula(n.X, this, fd)
+		if err != nil {
+			return x, err
+		}
+		return mapValue(x, func(v float64) float64 {
+			if n.Op == "-" {
+				return -v
+			}
+			return boolFloat(v == 0)
+		}), nil
+	case binaryNode:
+		left, err := evaluateFormula(n.Left, this, fd)
+		if err != nil {
+			return left, err
+		}
+		right, err := evaluateFormula(n.Right, this, fd)
+		if err != nil {
+			return right, err
+		}
+		return combineValues(left, right, binaryOperator(n.Op)), nil
+	case ternaryNode:
+		cond, err := evaluateFormula(n.Cond, this, fd)
+		if err != nil {
+			return cond, err
+		}
+		then, err := evaluateFormula(n.Then, this, fd)
+		if err != nil {
+			return then, err
+		}
+		otherwise, err := evaluateFormula(n.Else, this, fd)
+		if err != nil {
+			return otherwise, err
+		}
+		selected := combineValues(cond, then, func(c float64, t float64) float64 {
+			if c != 0 {
+				return t
+			}
+			return math.NaN()
+		})
+		return combineValues(selected, combineValues(cond, otherwise, func(c float64, o float64) float64 {
+			if c == 0 {
+				return o
+			}
+			return math.NaN()
+		}), func(a float64, b float64) float64 {
+			if math.IsNaN(a) {
+				return b
+			}
+			return a
+		}), nil
+	case callNode:
+		return evaluateCall(n, this, fd)
+	}
+	return formulaValue{}, fmt.Errorf("unsupported expression %T", node)
+}
+
+func evaluateCall(n callNode, this types.UUID, fd *formulaData) (formulaValue, error) {
+	if loopingFunctions[n.Name] && len(n.Args) == 1 {
+		var members []series
+		if ref, ok := n.Args[0].(refNode); ok {
+			members = fd.members(ref.Ref, this)
+		} else {
+			v, err := evaluateFormula(n.Args[0], this, fd)
+			if err != nil {
+				return v, err
+			}
+			if !v.IsSeries {
+				return v, nil
+			}
+			members = []series{v.Series}
+		}
+		return seriesValue(aggregateSeries(n.Name, members)), nil
+	}
+
+	args := make([]formulaValue, 0, len(n.Args))
+	for _, arg := range n.Args {
+		v, err := evaluateFormula(arg, this, fd)
+		if err != nil {
+			return v, err
+		}
+		args = append(args, v)
+	}
+	switch n.Name {
+	case "min", "max":
+		result := args[0]
+		for _, arg := range args[1:] {
+			if n.Name == "min" {
+				result = combineValues(result, arg, math.Min)
+			} else {
+				result = combineValues(result, arg, math.Max)
+			}
+		}
+		return result, nil
+	case "pow":
+		return combineValues(args[0], args[1], math.Pow), nil
+	}
+	return mapValue(args[0], singleFunctions[n.Name]), nil
+}
+
+// members returns the series of all resources a reference points to from the given resource
+func (fd *formulaData) members(ref formulaRef, this types.UUID) []series {
+	var ids []types.UUID
+	switch {
+	case ref.This:
+		ids = []types.UUID{this}
+	case ref.Name != "":
+		ids = fd.Named[ref.Name]
+	default:
+		ids = fd.Related[ref.relationKey()][this]
+	}
+	members := make([]series, 0, len(ids))
+	for _, id := range ids {
+		s, ok := fd.Stats[id][ref.Metric]
+		if !ok {
+			continue
+		}
+		if ref.Where != nil {
+			filtered := make(series)
+			for ts, v := range s {
+				if binaryOperator(ref.Where.Operand)(v, ref.Where.Value) != 0 {
+					filtered[ts] = v
+				}
+			}
+			s = filtered
+		}
+		members = append(members, s)
+	}
+	return members
+}
+
+// aggregateSeries combines the members point by point, timestamps missing in a member are skipped
+func aggregateSeries(function string, members []series) series {
+	values := make(map[int64][]float64)
+	for _, member := range members {
+		for ts, v := range member {
+			values[ts] = append(values[ts], v)
+		}
+	}
+	result := make(series, len(values))
+	for ts, points := range values {
+		result[ts] = aggregate(function, points)
+	}
+	return result
+}
+
+func aggregate(function string, points []float64) float64 {
+	switch function {
+	case "count":
+		return float64(len(points))
+	case "sum", "avg":
+		sum := 0.0
+		for _, v := range points {
+			sum += v
+		}
+		if function == "avg" {
+			return sum / float64(len(points))
+		}
+		return sum
+	case "min":
+		result := math.Inf(1)
+		for _, v := range points {
+			result = math.Min(result, v)
+		}
+		return result
+	case "max":
+		result := math.Inf(-1)
+		for _, v := range points {
+			result = math.Max(result, v)
+		}
+		return result
+	}
+	return math.NaN()
+}
+
+func binaryOperator(op string) func(float64, float64) float64 {
+	switch op {
+	case "+":
+		return func(a float64, b float64) float64 { return a + b }
+	case "-":
+		return func(a float64, b float64) float64 { return a - b }
+	case "*":
+		return func(a float64, b float64) float64 { return a * b }
+	case "/":
+		return func(a float64, b float64) float64 { return a / b }
+	case "%":
+		return math.Mod
+	case "==":
+		return func(a float64, b float64) float64 { return boolFloat(a == b) }
+	case "!=":
+		return func(a float64, b float64) float64 { return boolFloat(a != b) }
+	case "<":
+		return func(a float64, b float64) float64 { return boolFloat(a < b) }
+	case "<=":
+		return func(a float64, b float64) float64 { return boolFloat(a <= b) }
+	case ">":
+		return func(a float64, b float64) float64 { return boolFloat(a > b) }
+	case ">=":
+		return func(a float64, b float64) float64 { return boolFloat(a >= b) }
+	case "&&":
+		return func(a float64, b float64) float64 { return boolFloat(a != 0 && b != 0) }
+	case "||":
+		return func(a float64, b float64) float64 { return boolFloat(a != 0 || b != 0) }
+	}
+	return func(a float64, b float64) float64 { return math.NaN() }
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func mapValue(x formulaValue, fn func(float64) float64) formulaValue {
+	if !x.IsSeries {
+		return scalarValue(fn(x.Scalar))
+	}
+	result := make(series, len(x.Series))
+	for ts, v := range x.Series {
+		result[ts] = fn(v)
+	}
+	return seriesValue(result)
+}
+
+// combineValues applies fn to two values, series are matched on equal timestamps
+func combineValues(left formulaValue, right formulaValue, fn func(float64, float64) float64) formulaValue {
+	switch {
+	case !left.IsSeries && !right.IsSeries:
+		return scalarValue(fn(left.Scalar, right.Scalar))
+	case !right.IsSeries:
+		return mapValue(left, func(v float64) float64 { return fn(v, right.Scalar) })
+	case !left.IsSeries:
+		return mapValue(right, func(v float64) float64 { return fn(left.Scalar, v) })
+	}
+	result := make(series)
+	for ts, l := range left.Series {
+		if r, ok := right.Series[ts]; ok {
+			result[ts] = fn(l, r)
+		}
+	}
+	return seriesValue(result)
+}
+
+// aligned returns the series with timestamps truncated to the step, the latest value within a step is kept
+func (s series) aligned(step time.Duration) series {
+	stepMilli := step.Milliseconds()
+	if stepMilli <= 0 {
+		return s
+	}
+	result := make(series, len(s))
+	latest := make(map[int64]int64, len(s))
+	for ts, v := range s {
+		bucket := ts - ts%stepMilli
+		if previous, ok := latest[bucket]; !ok || ts > previous {
+			latest[bucket] = ts
+			result[bucket] = v
+		}
+	}
+	return result
+}
+
+// sorted returns timestamps and values of a series ordered by time, NaN values are dropped
+func (s series) sorted() ([]int64, []float64) {
+	timestamps := make([]int64, 0, len(s))
+	for ts, v := range s {
+		if !math.IsNaN(v) {
+			timestamps = append(timestamps, ts)
+		}
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	values := make([]float64, len(timestamps))
+	for i, ts := range timestamps {
+		values[i] = s[ts]
+	}
+	return timestamps, values
+}
@@ -0,0 +1,166 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/oapi-codegen/runtime/types"
+)
+
+func TestParseFormulaErrors(t *testing.T) {
+	tests := []struct {
+		formula string
+		err     string
+	}{
+		{formula: "", err: "unexpected"},
+		{formula: "1 +", err: "unexpected"},
+		{formula: "(1 + 2", err: `expected ")"`},
+		{formula: "1 2", err: "unexpected"},
+		{formula: "$x", err: "expected '{'"},
+		{formula: "${this, metric=cpu", err: "unterminated reference"},
+		{formula: "1 # 2", err: "unexpected character"},
+		{formula: "${this}", err: "has no metric"},
+		{formula: "${metric=cpu}", err: "needs this, objectname or objecttype"},
+		{formula: "${this, metric=cpu, color=red}", err: "unsupported reference attribute"},
+		{formula: "${objecttype=VirtualMachine, metric=cpu, depth=0}", err: "invalid depth"},
+		{formula: "${this, metric=cpu, where=($value ~ 1)}", err: "invalid where operator"},
+		{formula: "${this, metric=cpu, where=(cpu > 1)}", err: "only where"},
+		{formula: "median(1)", err: "unsupported function"},
+		{formula: "pow(2)", err: "pow takes two arguments"},
+		{formula: "sqrt(1, 2)", err: "sqrt takes one argument"},
+		{formula: "sum(1, 2)", err: "sum takes one argument"},
+		{formula: "avg()", err: "avg needs at least one argument"},
+		{formula: "1 ? 2", err: `expected ":"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.formula, func(t *testing.T) {
+			_, err := parseFormula(tt.formula)
+			if err == nil {
+				t.Fatalf("expected an error containing %q", tt.err)
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected an error containing %q, got %q", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestEvaluateScalarFormula(t *testing.T) {
+	tests := []struct {
+		formula  string
+		expected float64
+	}{
+		{formula: "1 + 2 * 3", expected: 7},
+		{formula: "(1 + 2) * 3", expected: 9},
+		{formula: "10 - 4 - 3", expected: 3},
+		{formula: "12 / 3 / 2", expected: 2},
+		{formula: "7 % 4", expected: 3},
+		{formula: "-2 * 3", expected: -6},
+		{formula: "+2", expected: 2},
+		{formula: "!0 + !5", expected: 1},
+		{formula: "1 + 1 == 2", expected: 1},
+		{formula: "1 < 2 && 2 < 1", expected: 0},
+		{formula: "0 || 1 && 0", expected: 0},
+		{formula: "1 || 0 && 0", expected: 1},
+		{formula: "1 ? 2 : 3", expected: 2},
+		{formula: "0 ? 2 : 0 ? 3 : 4", expected: 4},
+		{formula: "1.5e1", expected: 15},
+		{formula: "max(1, 5, 3) + min(4, 2)", expected: 7},
+		{formula: "pow(2, 10)", expected: 1024},
+		{formula: "SQRT(16)", expected: 4},
+		{formula: "sum(5)", expected: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.formula, func(t *testing.T) {
+			node, err := parseFormula(tt.formula)
+			if err != nil {
+				t.Fatal(err)
+			}
+			value, err := evaluateFormula(node, types.UUID{}, &formulaData{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value.IsSeries {
+				t.Fatalf("expected a scalar, got %v", value.Series)
+			}
+			if value.Scalar != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, value.Scalar)
+			}
+		})
+	}
+}
+
+func TestParseFormulaRef(t *testing.T) {
+	node, err := parseFormula(`${adaptertype=VMWARE, objecttype=VirtualMachine, metric=cpu|usage_average, depth=-2, where=($value >= 10)}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, ok := node.(refNode)
+	if !ok {
+		t.Fatalf("expected a reference, got %T", node)
+	}
+	expected := formulaRef{AdapterKind: "VMWARE", ResourceKind: "VirtualMachine", Metric: "cpu|usage_average", Depth: -2, Where: &formulaWhere{Operand: ">=", Value: 10}}
+	if ref.Ref.AdapterKind != expected.AdapterKind || ref.Ref.ResourceKind != expected.ResourceKind || ref.Ref.Metric != expected.Metric ||
+		ref.Ref.Depth != expected.Depth || ref.Ref.Where == nil || *ref.Ref.Where != *expected.Where || ref.Ref.This {
+		t.Errorf("expected %+v, got %+v", expected, ref.Ref)
+	}
+}
+
+func TestEvaluateFormulaReferences(t *testing.T) {
+	host := uuid.New()
+	emptyHost := uuid.New()
+	vm1, vm2 := uuid.New(), uuid.New()
+	cluster := uuid.New()
+	fd := &formulaData{
+		Stats: map[types.UUID]map[string]series{
+			host:    {"cpu": {0: 10, 300000: 20}},
+			vm1:     {"mem": {0: 1, 300000: 2}},
+			vm2:     {"mem": {0: 3, 300000: 6}},
+			cluster: {"capacity": {0: 100, 300000: 100}},
+		},
+		Related: map[string]map[types.UUID][]types.UUID{
+			"|VirtualMachine|1": {host: {vm1, vm2}},
+		},
+		Named: map[string][]types.UUID{"cluster-1": {cluster}},
+	}
+	tests := []struct {
+		name     string
+		formula  string
+		this     types.UUID
+		expected series
+		err      string
+	}{
+		{name: "this", formula: "${this, metric=cpu} * 2", this: host, expected: series{0: 20, 300000: 40}},
+		{name: "sum of children", formula: "sum(${objecttype=VirtualMachine, metric=mem})", this: host, expected: series{0: 4, 300000: 8}},
+		{name: "count of children", formula: "count(${objecttype=VirtualMachine, metric=mem})", this: host, expected: series{0: 2, 300000: 2}},
+		{name: "where", formula: "max(${objecttype=VirtualMachine, metric=mem, where=($value < 5)})", this: host, expected: series{0: 3, 300000: 2}},
+		{name: "named resource", formula: "${this, metric=cpu} / ${objectname=cluster-1, metric=capacity} * 100", this: host, expected: series{0: 10, 300000: 20}},
+		{name: "ternary on series", formula: "${this, metric=cpu} > 15 ? 1 : 0", this: host, expected: series{0: 0, 300000: 1}},
+		{name: "resource without data", formula: "${this, metric=cpu} + 1", this: emptyHost, expected: series{}},
+		{name: "missing metric", formula: "${this, metric=disk}", this: host, expected: series{}},
+		{name: "several resources without looping function", formula: "${objecttype=VirtualMachine, metric=mem}", this: host, err: "resolves to 2 resources"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := parseFormula(tt.formula)
+			if err != nil {
+				t.Fatal(err)
+			}
+			value, err := evaluateFormula(node, tt.this, fd)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !value.IsSeries {
+				t.Fatalf("expected a series, got %v", value.Scalar)
+			}
+			assertSeries(t, tt.expected, value.Series)
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"sort"
+	"strings"
 	"swisscom-vmwareariaoperations-datasource/pkg/api"
 	"time"
 
@@ -256,3 +257,256 @@ func tableFrame(metrics *[]api.StatsOfResource, resourceIds map[types.UUID]*api.
 	}
 	return &response
 }
+
+// platformHealthFrame returns one row for the Aria node and one row per service running on it.
+func platformHealthFrame(health *platformHealth) *backend.DataResponse {
+	var response backend.DataResponse
+
+	kind := []string{"node"}
+	name := []string{"node"}
+	state := []string{health.Node.Status}
+	uptime := []*int64{nil}
+	startedOn := []*time.Time{nil}
+	details := []*string{health.Node.Details}
+	for _, service := range health.Services {
+		kind = append(kind, "service")
+		if service.Name != nil {
+			name = append(name, string(*service.Name))
+		} else {
+			name = append(name, "")
+		}
+		state = append(state, string(service.Health))
+		uptime = append(uptime, service.Uptime)
+		if service.StartedOn != nil {
+			started := time.UnixMilli(*service.StartedOn)
+			startedOn = append(startedOn, &started)
+		} else {
+			startedOn = append(startedOn, nil)
+		}
+		details = append(details, service.Details)
+	}
+
+	frame := data.NewFrame("platformHealth",
+		data.NewField("type", nil, kind),
+		data.NewField("name", nil, name),
+		data.NewField("state", nil, state),
+		data.NewField("uptime", nil, uptime).SetConfig(&data.FieldConfig{Unit: "ms"}),
+		data.NewField("startedOn", nil, startedOn),
+		data.NewField("details", nil, details),
+	).SetMeta(&data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+	})
+	response.Frames = append(response.Frames, frame)
+	return &response
+}
+
+// collectorsFrame returns one frame listing collectors with the adapter instances running on them
+// and one frame summarising the state of every collector group.
+func collectorsFrame(collectors []collectorHealth, groups []api.CollectorGroup) *backend.DataResponse {
+	var response backend.DataResponse
+
+	stateById := make(map[string]string)
+	collectorsFrame := data.NewFrame("collectors",
+		data.NewField("id", nil, []string{}),
+		data.NewField("name", nil, []string{}),
+		data.NewField("hostName", nil, []string{}),
+		data.NewField("type", nil, []string{}),
+		data.NewField("state", nil, []string{}),
+		data.NewField("local", nil, []bool{}),
+		data.NewField("lastHeartbeat", nil, []*time.Time{}),
+		data.NewField("collectorGroups", nil, []string{}),
+		data.NewField("adapters", nil, []string{}),
+		data.NewField("adapterCount", nil, []int64{}),
+	).SetMeta(&data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+	})
+	for _, ch := range collectors {
+		c := ch.Collector
+		var name, hostName, collectorType, state string
+		if c.Name != nil {
+			name = *c.Name
+		}
+		if c.HostName != nil {
+			hostName = *c.HostName
+		}
+		if c.Type != nil {
+			collectorType = string(*c.Type)
+		}
+		if c.State != nil {
+			state = string(*c.State)
+		}
+		stateById[c.Id] = state
+		var lastHeartbeat *time.Time
+		if c.LastHeartbeat != nil {
+			lastHeartbeat = &c.LastHeartbeat.Time
+		}
+		adapters := make([]string, 0, len(ch.Adapters))
+		for _, adapter := range ch.Adapters {
+			if adapter.ResourceKey != nil {
+				adapters = append(adapters, fmt.Sprintf("%s (%s)", adapter.ResourceKey.Name, adapter.ResourceKey.AdapterKindKey))
+			}
+		}
+		collectorsFrame.AppendRow(c.Id, name, hostName, collectorType, state, c.Local, lastHeartbeat,
+			strings.Join(ch.Groups, ", "), strings.Join(adapters, ", "), int64(len(ch.Adapters)))
+	}
+	response.Frames = append(response.Frames, collectorsFrame)
+
+	groupsFrame := data.NewFrame("collectorGroups",
+		data.NewField("name", nil, []string{}),
+		data.NewField("description", nil, []string{}),
+		data.NewField("haEnabled", nil, []bool{}),
+		data.NewField("state", nil, []string{}),
+		data.NewField("collectors", nil, []int64{}),
+		data.NewField("collectorsDown", nil, []int64{}),
+	).SetMeta(&data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+	})
+	for _, group := range groups {
+		var description string
+		if group.Description != nil {
+			description = *group.Description
+		}
+		haEnabled := group.HaEnabled != nil && *group.HaEnabled
+		var total, down int64
+		if group.CollectorIds != nil {
+			for _, collectorId := range *group.CollectorIds {
+				total++
+				if stateById[fmt.Sprint(collectorId)] != string(api.CollectorStateUP) {
+					down++
+				}
+			}
+		}
+		state := string(api.CollectorStateUP)
+		switch {
+		case total > 0 && down == total:
+			state = string(api.CollectorStateDOWN)
+		case down > 0:
+			state = "DEGRADED"
+		}
+		groupsFrame.AppendRow(group.Name, description, haEnabled, state, total, down)
+	}
+	response.Frames = append(response.Frames, groupsFrame)
+	return &response
+}
+
+// adapterInstancesFrame returns one row per adapter instance with its collection state. The age of the
+// last collection is exposed as a number so that alert rules can fire when an adapter stops collecting.
+func adapterInstancesFrame(instances []adapterInstance, collectors []collector, now time.Time) *backend.DataResponse {
+	var response backend.DataResponse
+
+	collectorNames := make(map[string]string)
+	for _, c := range collectors {
+		if c.Name != nil {
+			collectorNames[c.Id] = *c.Name
+		}
+	}
+
+	frame := data.NewFrame("adapterInstances",
+		data.NewField("id", nil, []string{}),
+		data.NewField("name", nil, []string{}),
+		data.NewField("adapterKind", nil, []string{}),
+		data.NewField("resourceKind", nil, []string{}),
+		data.NewField("collector", nil, []string{}),
+		data.NewField("lastCollected", nil, []*time.Time{}),
+		data.NewField("lastHeartbeat", nil, []*time.Time{}),
+		data.NewField("sinceLastCollection", nil, []*float64{}).SetConfig(&data.FieldConfig{Unit: "s"}),
+		data.NewField("resourcesCollected", nil, []*int32{}),
+		data.NewField("metricsCollected", nil, []*int32{}),
+		data.NewField("message", nil, []string{}),
+	).SetMeta(&data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+	})
+	for _, instance := range instances {
+		var id, name, adapterKind, resourceKind, collectorName, message string
+		if instance.Id != nil {
+			id = instance.Id.String()
+		}
+		if instance.ResourceKey != nil {
+			name = instance.ResourceKey.Name
+			adapterKind = instance.ResourceKey.AdapterKindKey
+			resourceKind = instance.ResourceKey.ResourceKindKey
+		}
+		if instance.CollectorId != nil {
+			collectorId := fmt.Sprint(*instance.CollectorId)
+			collectorName = collectorNames[collectorId]
+			if collectorName == "" {
+				collectorName = collectorId
+			}
+		}
+		if instance.MessageFromAdapterInstance != nil {
+			message = *instance.MessageFromAdapterInstance
+		}
+		var lastCollected, lastHeartbeat *time.Time
+		var sinceLastCollection *float64
+		if instance.LastCollected != nil {
+			lastCollected = &instance.LastCollected.Time
+			since := now.Sub(instance.LastCollected.Time).Seconds()
+			sinceLastCollection = &since
+		}
+		if instance.LastHeartbeat != nil {
+			lastHeartbeat = &instance.LastHeartbeat.Time
+		}
+		frame.AppendRow(id, name, adapterKind, resourceKind, collectorName, lastCollected, lastHeartbeat,
+			sinceLastCollection, instance.NumberOfResourcesCollected, instance.NumberOfMetricsCollected, message)
+	}
+	response.Frames = append(response.Frames, frame)
+	return &response
+}
+
+// maintenanceFrame returns every maintenance window within the time range as a region annotation.
+func maintenanceFrame(schedules []api.MaintenanceSchedule, from time.Time, to time.Time, q queryModel) *backend.DataResponse {
+	var response backend.DataResponse
+
+	frame := data.NewFrame("maintenance",
+		data.NewField("time", nil, []time.Time{}),
+		data.NewField("timeEnd", nil, []time.Time{}),
+		data.NewField("title", nil, []string{}),
+		data.NewField("text", nil, []string{}),
+	)
+	for _, schedule := range schedules {
+		if !filterText(q.BuilderOptions.CustomFilters, map[string]string{"name": schedule.Key}) {
+			continue
+		}
+		text := scheduleDescription(schedule.Schedule)
+		for _, window := range scheduleWindows(schedule.Schedule, from, to) {
+			frame.AppendRow(window.Start, window.End, schedule.Key, text)
+		}
+	}
+	response.Frames = append(response.Frames, frame)
+	return &response
+}
+
+// seriesFrame builds a time series frame in the same shape as timeSeriesFrame from a computed series
+func seriesFrame(name string, labels data.Labels, s series) *data.Frame {
+	timestamps, values := s.sorted()
+	times := make([]time.Time, len(timestamps))
+	for i, ts := range timestamps {
+		times[i] = time.UnixMilli(ts)
+	}
+	return data.NewFrame("",
+		data.NewField("time", nil, times),
+		data.NewField(name, labels, values),
+	).SetMeta(&data.FrameMeta{
+		Type:        data.FrameTypeTimeSeriesMulti,
+		TypeVersion: data.FrameTypeVersion{0, 1},
+		Custom:      CustomMeta{ResultType: "matrix"},
+	})
+}
+
+// frameSeries reads the values of a time series frame, null values are skipped
+func frameSeries(frame *data.Frame) (data.Labels, series, bool) {
+	if len(frame.Fields) < 2 {
+		return nil, nil, false
+	}
+	timeField, valueField := frame.Fields[0], frame.Fields[1]
+	s := make(series, timeField.Len())
+	for i := 0; i < timeField.Len(); i++ {
+		ts, tsOk := timeField.At(i).(time.Time)
+		v, vOk := valueField.ConcreteAt(i)
+		value, floatOk := v.(float64)
+		if tsOk && vOk && floatOk {
+			s[ts.UnixMilli()] = value
+		}
+	}
+	return valueField.Labels, s, true
+}
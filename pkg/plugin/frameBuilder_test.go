@@ -0,0 +1,176 @@
+package plugin
+
+import (
+	"reflect"
+	"swisscom-vmwareariaoperations-datasource/pkg/api"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// frameRows returns the field names of the frame and its rows with pointers dereferenced, nil for a missing value
+func frameRows(frame *data.Frame) ([]string, [][]interface{}) {
+	names := make([]string, len(frame.Fields))
+	for i, field := range frame.Fields {
+		names[i] = field.Name
+	}
+	rows := make([][]interface{}, frame.Rows())
+	for row := range rows {
+		rows[row] = make([]interface{}, len(frame.Fields))
+		for i, field := range frame.Fields {
+			if value, ok := field.ConcreteAt(row); ok {
+				rows[row][i] = value
+			}
+		}
+	}
+	return names, rows
+}
+
+func assertFrame(t *testing.T, frame *data.Frame, name string, fields []string, rows [][]interface{}) {
+	t.Helper()
+	if frame.Name != name {
+		t.Errorf("expected frame %s, got %s", name, frame.Name)
+	}
+	if frame.Meta == nil || frame.Meta.PreferredVisualization != data.VisTypeTable {
+		t.Errorf("expected frame %s to be shown as table", name)
+	}
+	names, values := frameRows(frame)
+	if !reflect.DeepEqual(names, fields) {
+		t.Errorf("expected fields %v, got %v", fields, names)
+	}
+	if !reflect.DeepEqual(values, rows) {
+		t.Errorf("expected rows %v, got %v", rows, values)
+	}
+}
+
+func TestPlatformHealthFrame(t *testing.T) {
+	started := time.UnixMilli(1700000000000)
+	details, stopped := "all services running", "stopped"
+	apiService := api.ServiceNameAPI
+	uptime, startedOn := int64(60000), started.UnixMilli()
+	tests := []struct {
+		name   string
+		health *platformHealth
+		rows   [][]interface{}
+	}{
+		{
+			name:   "node without services",
+			health: &platformHealth{Node: &api.NodeStatus{Status: "ONLINE"}},
+			rows:   [][]interface{}{{"node", "node", "ONLINE", nil, nil, nil}},
+		},
+		{
+			name: "node with services",
+			health: &platformHealth{
+				Node: &api.NodeStatus{Status: "ONLINE", Details: &details},
+				Services: []api.Service{
+					{Name: &apiService, Health: api.ServiceHealthOK, Uptime: &uptime, StartedOn: &startedOn},
+					{Health: api.ServiceHealthERROR, Details: &stopped},
+				},
+			},
+			rows: [][]interface{}{
+				{"node", "node", "ONLINE", nil, nil, details},
+				{"service", "API", "OK", int64(60000), started, nil},
+				{"service", "", "ERROR", nil, nil, "stopped"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := platformHealthFrame(tt.health)
+			if len(response.Frames) != 1 {
+				t.Fatalf("expected one frame, got %d", len(response.Frames))
+			}
+			assertFrame(t, response.Frames[0], "platformHealth", []string{"type", "name", "state", "uptime", "startedOn", "details"}, tt.rows)
+			if unit := response.Frames[0].Fields[3].Config.Unit; unit != "ms" {
+				t.Errorf("expected the uptime in ms, got %q", unit)
+			}
+		})
+	}
+}
+
+func TestCollectorsFrame(t *testing.T) {
+	heartbeat := time.UnixMilli(1700000000000)
+	name, host, up, down, proxy := "cloud proxy", "proxy.example.com", api.CollectorStateUP, api.CollectorStateDOWN, api.CollectorTypeCLOUDPROXY
+	description, ha := "site A", true
+	collectors := []collectorHealth{
+		{
+			Collector: collector{Collector: api.Collector{Id: "1", Name: &name, HostName: &host, Type: &proxy, State: &up}, LastHeartbeat: &ariaTime{heartbeat}},
+			Groups:    []string{"site A", "all"},
+			Adapters: []adapterInstance{
+				{AdapterInstance: api.AdapterInstance{ResourceKey: &api.ResourceKey{Name: "vCenter", AdapterKindKey: "VMWARE"}}},
+				// Adapter instances without resource key are counted but not named
+				{},
+			},
+		},
+		{Collector: collector{Collector: api.Collector{Id: "2", State: &down, Local: true}}},
+		{Collector: collector{Collector: api.Collector{Id: "3", State: &down}}},
+	}
+	groups := []api.CollectorGroup{
+		{Name: "up", CollectorIds: &[]int32{1}},
+		{Name: "degraded", Description: &description, HaEnabled: &ha, CollectorIds: &[]int32{1, 2}},
+		{Name: "down", CollectorIds: &[]int32{2, 3}},
+		{Name: "empty"},
+	}
+
+	response := collectorsFrame(collectors, groups)
+	if len(response.Frames) != 2 {
+		t.Fatalf("expected a collectors and a groups frame, got %d frames", len(response.Frames))
+	}
+	assertFrame(t, response.Frames[0], "collectors",
+		[]string{"id", "name", "hostName", "type", "state", "local", "lastHeartbeat", "collectorGroups", "adapters", "adapterCount"},
+		[][]interface{}{
+			{"1", name, host, "CLOUD_PROXY", "UP", false, heartbeat, "site A, all", "vCenter (VMWARE)", int64(2)},
+			{"2", "", "", "", "DOWN", true, nil, "", "", int64(0)},
+			{"3", "", "", "", "DOWN", false, nil, "", "", int64(0)},
+		})
+	assertFrame(t, response.Frames[1], "collectorGroups",
+		[]string{"name", "description", "haEnabled", "state", "collectors", "collectorsDown"},
+		[][]interface{}{
+			{"up", "", false, "UP", int64(1), int64(0)},
+			{"degraded", description, true, "DEGRADED", int64(2), int64(1)},
+			{"down", "", false, "DOWN", int64(2), int64(2)},
+			{"empty", "", false, "UP", int64(0), int64(0)},
+		})
+}
+
+func TestAdapterInstancesFrame(t *testing.T) {
+	now := time.UnixMilli(1700000000000)
+	lastCollected, lastHeartbeat := now.Add(-5*time.Minute), now.Add(-time.Minute)
+	id := uuid.NewSHA1(uuid.Nil, []byte("vcenter"))
+	named, unnamed := int32(1), int32(7)
+	resources, metrics := int32(120), int32(4000)
+	message, collectorName := "collecting", "cloud proxy"
+	instances := []adapterInstance{
+		{
+			AdapterInstance: api.AdapterInstance{
+				Id:                         &id,
+				ResourceKey:                &api.ResourceKey{Name: "vCenter", AdapterKindKey: "VMWARE", ResourceKindKey: "VMwareAdapter Instance"},
+				CollectorId:                &named,
+				NumberOfResourcesCollected: &resources,
+				NumberOfMetricsCollected:   &metrics,
+				MessageFromAdapterInstance: &message,
+			},
+			LastCollected: &ariaTime{lastCollected},
+			LastHeartbeat: &ariaTime{lastHeartbeat},
+		},
+		// A collector Aria did not list is shown by its id, an instance which never collected has no age
+		{AdapterInstance: api.AdapterInstance{CollectorId: &unnamed}},
+	}
+	collectors := []collector{{Collector: api.Collector{Id: "1", Name: &collectorName}}}
+
+	response := adapterInstancesFrame(instances, collectors, now)
+	if len(response.Frames) != 1 {
+		t.Fatalf("expected one frame, got %d", len(response.Frames))
+	}
+	assertFrame(t, response.Frames[0], "adapterInstances",
+		[]string{"id", "name", "adapterKind", "resourceKind", "collector", "lastCollected", "lastHeartbeat", "sinceLastCollection", "resourcesCollected", "metricsCollected", "message"},
+		[][]interface{}{
+			{id.String(), "vCenter", "VMWARE", "VMwareAdapter Instance", collectorName, lastCollected, lastHeartbeat, float64(300), resources, metrics, message},
+			{"", "", "", "", "7", nil, nil, nil, nil, nil, ""},
+		})
+	if unit := response.Frames[0].Fields[7].Config.Unit; unit != "s" {
+		t.Errorf("expected the age of the last collection in s, got %q", unit)
+	}
+}
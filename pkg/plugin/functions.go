@@ -0,0 +1,219 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"swisscom-vmwareariaoperations-datasource/pkg/api"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
+)
+
+const (
+	FunctionRate        = "rate"
+	FunctionDelta       = "delta"
+	FunctionDerivative  = "derivative"
+	FunctionMovingAvg   = "movingAvg"
+	FunctionMovingMax   = "movingMax"
+	FunctionSmoothing   = "exponentialSmoothing"
+	defaultWindowPoints = 5
+)
+
+var rollupIntervals = map[api.IntervalUnitIntervalType]time.Duration{
+	api.IntervalUnitIntervalTypeSECONDS: time.Second,
+	api.IntervalUnitIntervalTypeMINUTES: time.Minute,
+	api.IntervalUnitIntervalTypeHOURS:   time.Hour,
+	api.IntervalUnitIntervalTypeDAYS:    24 * time.Hour,
+	api.IntervalUnitIntervalTypeWEEKS:   7 * 24 * time.Hour,
+}
+
+// validate reports configuration errors of a series function.
+func (f SeriesFunction) validate() error {
+	switch f.Function {
+	case FunctionRate, FunctionDelta, FunctionDerivative, FunctionMovingAvg, FunctionMovingMax, FunctionSmoothing:
+	default:
+		return fmt.Errorf("unsupported function %q", f.Function)
+	}
+	if f.Window != "" {
+		window, err := gtime.ParseDuration(f.Window)
+		if err != nil {
+			return fmt.Errorf("invalid window of %s: %v", f.Function, err)
+		}
+		if window <= 0 {
+			return fmt.Errorf("window of %s must be positive", f.Function)
+		}
+	}
+	if f.Alpha < 0 || f.Alpha > 1 {
+		return fmt.Errorf("alpha of %s must be between 0 and 1, got %v", f.Function, f.Alpha)
+	}
+	if f.Function == FunctionSmoothing && f.Alpha == 0 && f.Window == "" {
+		return fmt.Errorf("%s needs an alpha or a window", f.Function)
+	}
+	return nil
+}
+
+// applySeriesFunctions transforms every stat of the metrics in place. Differences are scaled by the
+// time between samples and windows are durations, so results only depend on the rollup interval of
+// the data and not on how many points a panel shows.
+func applySeriesFunctions(metrics *[]api.StatsOfResource, functions []SeriesFunction) {
+	if metrics == nil || len(functions) == 0 {
+		return
+	}
+	for _, resource := range *metrics {
+		if resource.StatList == nil || resource.StatList.Stat == nil {
+			continue
+		}
+		stats := *resource.StatList.Stat
+		for i := range stats {
+			if stats[i].Data == nil {
+				continue
+			}
+			timestamps, values := stats[i].Timestamps, *stats[i].Data
+			interval := rollupInterval(stats[i])
+			for _, f := range functions {
+				timestamps, values = applySeriesFunction(f, interval, timestamps, values)
+			}
+			stats[i].Timestamps = timestamps
+			stats[i].Data = &values
+		}
+	}
+}
+
+// rollupInterval returns the interval between samples of a stat, reported by Aria for rolled up data
+// and otherwise estimated from the timestamps
+func rollupInterval(stat api.Stats) time.Duration {
+	if stat.IntervalUnit != nil {
+		if unit, ok := rollupIntervals[stat.IntervalUnit.IntervalType]; ok {
+			quantifier := int32(1)
+			if stat.IntervalUnit.Quantifier != nil && *stat.IntervalUnit.Quantifier > 0 {
+				quantifier = *stat.IntervalUnit.Quantifier
+			}
+			return time.Duration(quantifier) * unit
+		}
+	}
+	return medianInterval(stat.Timestamps)
+}
+
+// medianInterval estimates the interval between samples, the median is not affected by gaps in collection
+func medianInterval(timestamps []int64) time.Duration {
+	differences := make([]int64, 0, len(timestamps))
+	for i := 1; i < len(timestamps); i++ {
+		if d := timestamps[i] - timestamps[i-1]; d > 0 {
+			differences = append(differences, d)
+		}
+	}
+	if len(differences) == 0 {
+		return collectionInterval
+	}
+	sort.Slice(differences, func(i, j int) bool { return differences[i] < differences[j] })
+	return time.Duration(differences[len(differences)/2]) * time.Millisecond
+}
+
+func applySeriesFunction(f SeriesFunction, interval time.Duration, timestamps []int64, values []float64) ([]int64, []float64) {
+	window := time.Duration(defaultWindowPoints) * interval
+	if f.Window != "" {
+		if w, err := gtime.ParseDuration(f.Window); err == nil {
+			window = w
+		}
+	}
+	// A window shorter than the rollup interval would contain single samples only
+	if window < interval {
+		window = interval
+	}
+	switch f.Function {
+	case FunctionRate:
+		return differences(timestamps, values, func(previous float64, current float64, elapsed time.Duration) float64 {
+			increase := current - previous
+			// Counters restart at zero, so the current value is the increase since the reset
+			if increase < 0 {
+				increase = current
+			}
+			return increase / elapsed.Seconds()
+		})
+	case FunctionDerivative:
+		return differences(timestamps, values, func(previous float64, current float64, elapsed time.Duration) float64 {
+			return (current - previous) / elapsed.Seconds()
+		})
+	case FunctionDelta:
+		return differences(timestamps, values, func(previous float64, current float64, elapsed time.Duration) float64 {
+			return (current - previous) * float64(interval) / float64(elapsed)
+		})
+	case FunctionMovingAvg:
+		return movingWindow(timestamps, values, window, func(points []float64) float64 { return aggregate("avg", points) })
+	case FunctionMovingMax:
+		return movingWindow(timestamps, values, window, func(points []float64) float64 { return aggregate("max", points) })
+	case FunctionSmoothing:
+		return exponentialSmoothing(timestamps, values, f.Alpha, interval, window, f.Alpha == 0)
+	}
+	return timestamps, values
+}
+
+// differences applies fn to consecutive samples, the first sample has no predecessor and is dropped
+func differences(timestamps []int64, values []float64, fn func(previous float64, current float64, elapsed time.Duration) float64) ([]int64, []float64) {
+	resultTimestamps := make([]int64, 0, len(timestamps))
+	resultValues := make([]float64, 0, len(values))
+	previous := -1
+	for i := range timestamps {
+		if math.IsNaN(values[i]) {
+			continue
+		}
+		if previous >= 0 && timestamps[i] > timestamps[previous] {
+			elapsed := time.Duration(timestamps[i]-timestamps[previous]) * time.Millisecond
+			resultTimestamps = append(resultTimestamps, timestamps[i])
+			resultValues = append(resultValues, fn(values[previous], values[i], elapsed))
+		}
+		previous = i
+	}
+	return resultTimestamps, resultValues
+}
+
+// movingWindow applies fn to the samples within the window ending at every sample, windows without samples are NaN
+func movingWindow(timestamps []int64, values []float64, window time.Duration, fn func(points []float64) float64) ([]int64, []float64) {
+	result := make([]float64, len(values))
+	start := 0
+	for i := range timestamps {
+		for timestamps[start] <= timestamps[i]-window.Milliseconds() {
+			start++
+		}
+		points := make([]float64, 0, i-start+1)
+		for _, v := range values[start : i+1] {
+			if !math.IsNaN(v) {
+				points = append(points, v)
+			}
+		}
+		if len(points) == 0 {
+			// A window of missing values has no value
+			result[i] = math.NaN()
+			continue
+		}
+		result[i] = fn(points)
+	}
+	return timestamps, result
+}
+
+// exponentialSmoothing weights samples by the time passed since the previous one. Alpha is the factor for
+// one rollup interval, without alpha the weight of a sample decays to 1/e after the window.
+func exponentialSmoothing(timestamps []int64, values []float64, alpha float64, interval time.Duration, window time.Duration, useWindow bool) ([]int64, []float64) {
+	result := make([]float64, len(values))
+	smoothed := math.NaN()
+	var previous int64
+	for i := range timestamps {
+		switch {
+		case math.IsNaN(values[i]):
+		case math.IsNaN(smoothed):
+			smoothed = values[i]
+			previous = timestamps[i]
+		default:
+			elapsed := float64(timestamps[i] - previous)
+			previous = timestamps[i]
+			factor := 1 - math.Pow(1-alpha, elapsed/float64(interval.Milliseconds()))
+			if useWindow {
+				factor = 1 - math.Exp(-elapsed/float64(window.Milliseconds()))
+			}
+			smoothed += factor * (values[i] - smoothed)
+		}
+		result[i] = smoothed
+	}
+	return timestamps, result
+}
@@ -0,0 +1,166 @@
+package plugin
+
+import (
+	"math"
+	"swisscom-vmwareariaoperations-datasource/pkg/api"
+	"testing"
+	"time"
+)
+
+func TestSeriesFunctionValidate(t *testing.T) {
+	tests := []struct {
+		function SeriesFunction
+		valid    bool
+	}{
+		{function: SeriesFunction{Function: FunctionRate}, valid: true},
+		{function: SeriesFunction{Function: FunctionMovingAvg, Window: "30m"}, valid: true},
+		{function: SeriesFunction{Function: FunctionSmoothing, Alpha: 0.3}, valid: true},
+		{function: SeriesFunction{Function: FunctionSmoothing, Window: "1h"}, valid: true},
+		{function: SeriesFunction{Function: FunctionMovingAvg, Window: "1d"}, valid: true},
+		{function: SeriesFunction{Function: FunctionMovingMax, Window: "1w"}, valid: true},
+		{function: SeriesFunction{Function: "integral"}},
+		{function: SeriesFunction{Function: FunctionMovingMax, Window: "soon"}},
+		{function: SeriesFunction{Function: FunctionMovingMax, Window: "-5m"}},
+		{function: SeriesFunction{Function: FunctionSmoothing, Alpha: 1.5}},
+		{function: SeriesFunction{Function: FunctionSmoothing}},
+	}
+	for _, tt := range tests {
+		if err := tt.function.validate(); (err == nil) != tt.valid {
+			t.Errorf("%+v: expected valid %v, got %v", tt.function, tt.valid, err)
+		}
+	}
+}
+
+func TestApplySeriesFunction(t *testing.T) {
+	const interval = 5 * time.Minute
+	at := func(steps ...int64) []int64 {
+		timestamps := make([]int64, len(steps))
+		for i, step := range steps {
+			timestamps[i] = step * interval.Milliseconds()
+		}
+		return timestamps
+	}
+	nan := math.NaN()
+	tests := []struct {
+		name               string
+		function           SeriesFunction
+		timestamps         []int64
+		values             []float64
+		expectedTimestamps []int64
+		expectedValues     []float64
+	}{
+		{
+			name:               "rate restarts with the counter",
+			function:           SeriesFunction{Function: FunctionRate},
+			timestamps:         at(0, 1, 2),
+			values:             []float64{0, 300, 150},
+			expectedTimestamps: at(1, 2),
+			expectedValues:     []float64{1, 0.5},
+		},
+		{
+			name:               "derivative",
+			function:           SeriesFunction{Function: FunctionDerivative},
+			timestamps:         at(0, 1, 2),
+			values:             []float64{0, 300, 150},
+			expectedTimestamps: at(1, 2),
+			expectedValues:     []float64{1, -0.5},
+		},
+		{
+			name:               "delta is scaled to the rollup interval across gaps",
+			function:           SeriesFunction{Function: FunctionDelta},
+			timestamps:         at(0, 1, 3),
+			values:             []float64{0, 10, 30},
+			expectedTimestamps: at(1, 3),
+			expectedValues:     []float64{10, 10},
+		},
+		{
+			name:               "differences skip missing values",
+			function:           SeriesFunction{Function: FunctionDerivative},
+			timestamps:         at(0, 1, 2),
+			values:             []float64{0, nan, 600},
+			expectedTimestamps: at(2),
+			expectedValues:     []float64{1},
+		},
+		{
+			name:               "moving average over a window",
+			function:           SeriesFunction{Function: FunctionMovingAvg, Window: "10m"},
+			timestamps:         at(0, 1, 2, 3),
+			values:             []float64{1, 3, 5, nan},
+			expectedTimestamps: at(0, 1, 2, 3),
+			expectedValues:     []float64{1, 2, 4, 5},
+		},
+		{
+			name:               "moving max of a window without values",
+			function:           SeriesFunction{Function: FunctionMovingMax, Window: "5m"},
+			timestamps:         at(0, 1, 2),
+			values:             []float64{1, nan, 3},
+			expectedTimestamps: at(0, 1, 2),
+			expectedValues:     []float64{1, nan, 3},
+		},
+		{
+			name:               "moving average of a window without values",
+			function:           SeriesFunction{Function: FunctionMovingAvg, Window: "5m"},
+			timestamps:         at(0, 1),
+			values:             []float64{nan, 2},
+			expectedTimestamps: at(0, 1),
+			expectedValues:     []float64{nan, 2},
+		},
+		{
+			name:               "exponential smoothing weights gaps",
+			function:           SeriesFunction{Function: FunctionSmoothing, Alpha: 0.5},
+			timestamps:         at(0, 1, 3),
+			values:             []float64{0, 10, 10},
+			expectedTimestamps: at(0, 1, 3),
+			expectedValues:     []float64{0, 5, 8.75},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timestamps, values := applySeriesFunction(tt.function, interval, tt.timestamps, tt.values)
+			if len(timestamps) != len(tt.expectedTimestamps) || len(values) != len(tt.expectedValues) {
+				t.Fatalf("expected %v %v, got %v %v", tt.expectedTimestamps, tt.expectedValues, timestamps, values)
+			}
+			for i := range timestamps {
+				if timestamps[i] != tt.expectedTimestamps[i] {
+					t.Errorf("timestamp %d: expected %d, got %d", i, tt.expectedTimestamps[i], timestamps[i])
+				}
+				expected := tt.expectedValues[i]
+				if math.IsNaN(expected) != math.IsNaN(values[i]) || !math.IsNaN(expected) && math.Abs(values[i]-expected) > 1e-9 {
+					t.Errorf("value %d: expected %v, got %v", i, expected, values[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRollupInterval(t *testing.T) {
+	quantifier := int32(2)
+	tests := []struct {
+		name     string
+		stat     api.Stats
+		expected time.Duration
+	}{
+		{
+			name:     "reported by Aria",
+			stat:     api.Stats{IntervalUnit: &api.IntervalUnit{IntervalType: api.IntervalUnitIntervalTypeHOURS, Quantifier: &quantifier}},
+			expected: 2 * time.Hour,
+		},
+		{
+			name:     "median of timestamps",
+			stat:     api.Stats{Timestamps: []int64{0, 300000, 600000, 1800000, 2100000}},
+			expected: 5 * time.Minute,
+		},
+		{
+			name:     "single sample",
+			stat:     api.Stats{Timestamps: []int64{0}},
+			expected: collectionInterval,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if interval := rollupInterval(tt.stat); interval != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, interval)
+			}
+		})
+	}
+}
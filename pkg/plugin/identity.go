@@ -0,0 +1,176 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"swisscom-vmwareariaoperations-datasource/pkg/models"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// Users whose tokens are kept, tokens of further users replace expired ones
+const maxCachedUsers = 1000
+
+var errMissingIdentity = errors.New("the identity of the Grafana user was not forwarded, enable Forward OAuth identity and sign in with OAuth")
+
+// userIdentity is the OAuth identity Grafana forwards with requests of a signed in user
+type userIdentity struct {
+	Login string
+	Token string
+}
+
+// key identifies the cached tokens of the user, users without login are told apart by their token
+func (u *userIdentity) key() string {
+	if u.Login != "" {
+		return u.Login
+	}
+	sum := sha256.Sum256([]byte(u.Token))
+	return hex.EncodeToString(sum[:])
+}
+
+// expiry reads the expiry of a JWT identity token. It is not verified, Aria verifies the token, the expiry
+// only keeps Aria tokens of a user from outliving the session in Grafana.
+func (u *userIdentity) expiry() (time.Time, bool) {
+	parts := strings.Split(u.Token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
+
+type identityKey struct{}
+
+// withIdentity adds the OAuth identity forwarded by Grafana to the context, requests to Aria made with the
+// context are authenticated as the user.
+func withIdentity(ctx context.Context, user *backend.User, headers backend.ForwardHTTPHeaders) context.Context {
+	token, ok := strings.CutPrefix(headers.GetHTTPHeader(backend.OAuthIdentityTokenHeaderName), "Bearer ")
+	if !ok || token == "" {
+		token = headers.GetHTTPHeader(backend.OAuthIdentityIDTokenHeaderName)
+	}
+	if token == "" {
+		return ctx
+	}
+	identity := &userIdentity{Token: token}
+	if user != nil {
+		identity.Login = user.Login
+	}
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+func identityFromContext(ctx context.Context) *userIdentity {
+	identity, _ := ctx.Value(identityKey{}).(*userIdentity)
+	return identity
+}
+
+// userTokens are the tokens of one Grafana user, acquired with the latest identity the user was seen with
+type userTokens struct {
+	tokens   *tokenSource
+	identity atomic.Pointer[userIdentity]
+}
+
+// userTokenCache keeps a token source for every Grafana user, so users log in to Aria once and not with
+// every request.
+type userTokenCache struct {
+	config *models.PluginSettings
+	client *http.Client
+	// exchange is the client of the token exchange, shared by all users
+	exchange *http.Client
+	mu       sync.Mutex
+	users    map[string]*userTokens
+	// released caches create released token sources, so users do not log in anymore
+	released bool
+}
+
+func newUserTokenCache(config *models.PluginSettings, client *http.Client, exchange *http.Client) *userTokenCache {
+	return &userTokenCache{config: config, client: client, exchange: exchange, users: map[string]*userTokens{}}
+}
+
+// get returns the token source of the user, creating it for users seen the first time.
+func (c *userTokenCache) get(identity *userIdentity) *tokenSource {
+	key := identity.key()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	user, ok := c.users[key]
+	if !ok {
+		c.evict()
+		user = &userTokens{tokens: &tokenSource{config: c.config, client: c.client}}
+		user.tokens.released.Store(c.released)
+		user.tokens.acquire = func(ctx context.Context) (*ariaToken, error) {
+			return forwardIdentity(ctx, c.config, c.client, c.exchange, user.identity.Load())
+		}
+		c.users[key] = user
+	}
+	user.identity.Store(identity)
+	return user.tokens
+}
+
+// evict makes room for another user by dropping users without valid token, or any user if all are valid.
+// Must be called with the lock held.
+func (c *userTokenCache) evict() {
+	if len(c.users) < maxCachedUsers {
+		return
+	}
+	for key, user := range c.users {
+		if token := user.tokens.token.Load(); token == nil || token.expiresWithin(0) {
+			delete(c.users, key)
+		}
+	}
+	for key, user := range c.users {
+		if len(c.users) < maxCachedUsers {
+			return
+		}
+		delete(c.users, key)
+		go user.tokens.Release()
+	}
+}
+
+// Release releases the tokens of all users.
+func (c *userTokenCache) Release() {
+	c.mu.Lock()
+	users := c.users
+	c.users = map[string]*userTokens{}
+	c.released = true
+	c.mu.Unlock()
+	for _, user := range users {
+		user.tokens.Release()
+	}
+}
+
+// forwardIdentity acquires a token for a Grafana user. With a token exchange URL the identity token is
+// exchanged there, otherwise it is the credential of the user at the SSO auth source of Aria.
+func forwardIdentity(ctx context.Context, config *models.PluginSettings, hc *http.Client, exchange *http.Client, identity *userIdentity) (*ariaToken, error) {
+	backend.Logger.Debug("Acquiring token for Grafana user", "login", identity.Login)
+	var token *ariaToken
+	var err error
+	if config.Secrets.TokenExchangeUrl != "" {
+		token, err = exchangeToken(ctx, config, exchange, &identity.Token)
+	} else {
+		token, err = auth(ctx, config, hc, identity.Login, identity.Token)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if expiry, ok := identity.expiry(); ok && expiry.Before(token.ExpiresAt) {
+		token.ExpiresAt = expiry
+	}
+	return token, nil
+}
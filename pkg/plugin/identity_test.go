@@ -0,0 +1,245 @@
+package plugin
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"swisscom-vmwareariaoperations-datasource/pkg/models"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// fakeSSOAria issues a token for every user who logs in at the SSO auth source and shows every user their own
+// super metric named "capacity".
+type fakeSSOAria struct {
+	*httptest.Server
+	mu     sync.Mutex
+	logins map[string]int
+}
+
+func newFakeSSOAria(t *testing.T) *fakeSSOAria {
+	f := &fakeSSOAria{logins: map[string]int{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/api/auth/token/acquire" {
+			var credentials struct {
+				Username string `json:"username"`
+				Password string `json:"password"`
+			}
+			if err := json.NewDecoder(req.Body).Decode(&credentials); err != nil || credentials.Password != "id-"+credentials.Username {
+				rw.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			f.mu.Lock()
+			f.logins[credentials.Username]++
+			f.mu.Unlock()
+			writeJSON(rw, http.StatusOK, map[string]interface{}{"token": "token-" + credentials.Username, "validity": time.Now().Add(time.Hour).UnixMilli()})
+			return
+		}
+		user, ok := strings.CutPrefix(req.Header.Get("Authorization"), "OpsToken token-")
+		if !ok {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch req.URL.Path {
+		case "/api/supermetrics":
+			writeJSON(rw, http.StatusOK, map[string]interface{}{"superMetrics": []interface{}{map[string]interface{}{
+				"id":   uuid.NewSHA1(uuid.Nil, []byte(user)).String(),
+				"name": "capacity",
+			}}})
+		case "/api/auth/token/release":
+			rw.WriteHeader(http.StatusOK)
+		default:
+			t.Errorf("unexpected request %s", req.URL.Path)
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeSSOAria) pluginContext(t *testing.T) backend.PluginContext {
+	settings, err := json.Marshal(map[string]interface{}{"host": f.URL, "authMode": models.AuthModeForwardIdentity, "authSource": "sso", "rateLimit": 10000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{JSONData: settings}}
+}
+
+func (f *fakeSSOAria) loginsOf(user string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.logins[user]
+}
+
+// userContext is the context of a request Grafana sends for the signed in user
+func userContext(login string) context.Context {
+	req := &backend.QueryDataRequest{}
+	req.SetHTTPHeader(backend.OAuthIdentityTokenHeaderName, "Bearer id-"+login)
+	return withIdentity(context.Background(), &backend.User{Login: login}, req)
+}
+
+// jwt builds an unsigned identity token expiring at exp
+func jwt(exp time.Time) string {
+	claims := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, exp.Unix())))
+	return "header." + claims + ".signature"
+}
+
+func TestWithIdentity(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     *backend.User
+		headers  map[string]string
+		identity *userIdentity
+	}{
+		{name: "access token", user: &backend.User{Login: "alice"}, headers: map[string]string{backend.OAuthIdentityTokenHeaderName: "Bearer abc"}, identity: &userIdentity{Login: "alice", Token: "abc"}},
+		{name: "id token without access token", user: &backend.User{Login: "alice"}, headers: map[string]string{backend.OAuthIdentityIDTokenHeaderName: "xyz"}, identity: &userIdentity{Login: "alice", Token: "xyz"}},
+		{name: "access token preferred", headers: map[string]string{backend.OAuthIdentityTokenHeaderName: "Bearer abc", backend.OAuthIdentityIDTokenHeaderName: "xyz"}, identity: &userIdentity{Token: "abc"}},
+		{name: "basic authorization", user: &backend.User{Login: "alice"}, headers: map[string]string{backend.OAuthIdentityTokenHeaderName: "Basic abc"}},
+		{name: "no identity", user: &backend.User{Login: "alice"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &backend.QueryDataRequest{}
+			for key, value := range tt.headers {
+				req.SetHTTPHeader(key, value)
+			}
+			identity := identityFromContext(withIdentity(context.Background(), tt.user, req))
+			switch {
+			case tt.identity == nil && identity != nil:
+				t.Errorf("expected no identity, got %+v", identity)
+			case tt.identity != nil && (identity == nil || *identity != *tt.identity):
+				t.Errorf("expected %+v, got %+v", tt.identity, identity)
+			}
+		})
+	}
+}
+
+func TestForwardIdentity(t *testing.T) {
+	aria := newFakeSSOAria(t)
+	config := &models.PluginSettings{Host: aria.URL, AuthMode: models.AuthModeForwardIdentity, AuthSource: "sso", Secrets: &models.SecretPluginSettings{}}
+	tests := []struct {
+		name      string
+		identity  *userIdentity
+		expiresAt time.Time
+		err       bool
+	}{
+		{name: "token of the user", identity: &userIdentity{Login: "alice", Token: "id-alice"}, expiresAt: time.Now().Add(time.Hour)},
+		{name: "identity rejected by Aria", identity: &userIdentity{Login: "alice", Token: "id-bob"}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := forwardIdentity(context.Background(), config, http.DefaultClient, nil, tt.identity)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expected the login to fail, got %+v", token)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token.Value != "token-"+tt.identity.Login {
+				t.Errorf("expected the token of %s, got %s", tt.identity.Login, token.Value)
+			}
+			if token.ExpiresAt.Sub(tt.expiresAt).Abs() > time.Minute {
+				t.Errorf("expected the token to expire at %v, got %v", tt.expiresAt, token.ExpiresAt)
+			}
+		})
+	}
+}
+
+func TestForwardIdentityCapsExpiry(t *testing.T) {
+	session := time.Now().Add(10 * time.Minute).Truncate(time.Second)
+	exchange := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		writeJSON(rw, http.StatusOK, map[string]interface{}{"access_token": "aria", "expires_in": 3600})
+	}))
+	t.Cleanup(exchange.Close)
+	config := &models.PluginSettings{AuthMode: models.AuthModeForwardIdentity, Secrets: &models.SecretPluginSettings{TokenExchangeUrl: exchange.URL}}
+	token, err := forwardIdentity(context.Background(), config, nil, http.DefaultClient, &userIdentity{Login: "alice", Token: jwt(session)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !token.ExpiresAt.Equal(session) {
+		t.Errorf("expected the token to expire with the session at %v, got %v", session, token.ExpiresAt)
+	}
+}
+
+func TestUserTokenCacheEvicts(t *testing.T) {
+	// Exchanged tokens are not released, so evicting users does not call Aria
+	config := &models.PluginSettings{AuthMode: models.AuthModeForwardIdentity, Secrets: &models.SecretPluginSettings{TokenExchangeUrl: "https://vault.example.com"}}
+	fill := func(valid func(i int) bool) *userTokenCache {
+		cache := newUserTokenCache(config, nil, nil)
+		for i := 0; i < maxCachedUsers; i++ {
+			cache.get(&userIdentity{Login: fmt.Sprintf("user-%d", i)})
+			expiresAt := time.Now().Add(-time.Minute)
+			if valid(i) {
+				expiresAt = time.Now().Add(time.Hour)
+			}
+			cache.users[fmt.Sprintf("user-%d", i)].tokens.token.Store(&ariaToken{Value: "token", ExpiresAt: expiresAt})
+		}
+		return cache
+	}
+	tests := []struct {
+		name  string
+		valid func(i int) bool
+		users int
+	}{
+		{name: "users without valid token", valid: func(i int) bool { return i%2 == 0 }, users: maxCachedUsers/2 + 1},
+		{name: "any user when all are valid", valid: func(int) bool { return true }, users: maxCachedUsers},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := fill(tt.valid)
+			first := cache.get(&userIdentity{Login: "user-0"})
+			cache.get(&userIdentity{Login: "new"})
+			if len(cache.users) != tt.users {
+				t.Errorf("expected %d users, got %d", tt.users, len(cache.users))
+			}
+			if _, ok := cache.users["new"]; !ok {
+				t.Error("expected the new user to be cached")
+			}
+			if user, ok := cache.users["user-0"]; ok && user.tokens != first {
+				t.Error("expected a cached user to keep their tokens")
+			}
+		})
+	}
+}
+
+// Every user authenticates with their own identity and only sees what Aria shows them
+func TestForwardedUsersAreIsolated(t *testing.T) {
+	aria := newFakeSSOAria(t)
+	pc := aria.pluginContext(t)
+	d := newTestDatasource(t)
+	if err := d.ensureClient(context.Background(), &pc); err != nil {
+		t.Fatal(err)
+	}
+	for round := 0; round < 3; round++ {
+		for _, user := range []string{"alice", "bob"} {
+			// The super metric is looked up once per user, cached keys are not shared
+			key, err := d.superMetricKey(userContext(user), "capacity")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := superMetricStatKey(uuid.NewSHA1(uuid.Nil, []byte(user))); key != expected {
+				t.Errorf("round %d: expected the super metric of %s, got %s", round, user, key)
+			}
+		}
+	}
+	for _, user := range []string{"alice", "bob"} {
+		if logins := aria.loginsOf(user); logins != 1 {
+			t.Errorf("expected %s to log in once, got %d", user, logins)
+		}
+	}
+	if _, err := d.superMetricKey(context.Background(), "other"); !errors.Is(err, errMissingIdentity) {
+		t.Errorf("expected requests without identity to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,216 @@
+package plugin
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"swisscom-vmwareariaoperations-datasource/pkg/api"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// Aria transfers schedule dates as MM/DD/YYYY
+const scheduleDateLayout = "01/02/2006"
+
+type maintenanceWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+// scheduleWindows expands a maintenance schedule into the windows which overlap the range between from and to.
+func scheduleWindows(s api.Schedule, from time.Time, to time.Time) []maintenanceWindow {
+	windows := make([]maintenanceWindow, 0)
+	location := time.UTC
+	if s.TimeZone != nil && *s.TimeZone != "" {
+		l, err := time.LoadLocation(*s.TimeZone)
+		if err != nil {
+			backend.Logger.Warn("Unknown maintenance schedule time zone, using UTC", "timeZone", *s.TimeZone, "error", err)
+		} else {
+			location = l
+		}
+	}
+	duration := time.Duration(s.Duration) * time.Minute
+
+	// Recurrences are counted from the start date of the schedule. Without one they are counted from a
+	// fixed day, so runs do not move with the requested range.
+	start := time.Date(1970, 1, 1, 0, 0, 0, 0, location)
+	hasStart := s.StartDate != nil && *s.StartDate != ""
+	if hasStart {
+		d, err := time.ParseInLocation(scheduleDateLayout, *s.StartDate, location)
+		if err != nil {
+			backend.Logger.Warn("Unable to parse maintenance schedule start date", "startDate", *s.StartDate, "error", err)
+			return windows
+		}
+		start = time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, location)
+	} else if s.ScheduleType == api.ScheduleScheduleTypeONCE {
+		// Without a start date a single run cannot be placed in time
+		return windows
+	}
+
+	end := to
+	if s.ExpirationDate != nil && *s.ExpirationDate != "" {
+		d, err := time.ParseInLocation(scheduleDateLayout, *s.ExpirationDate, location)
+		if err != nil {
+			backend.Logger.Warn("Unable to parse maintenance schedule expiration date", "expirationDate", *s.ExpirationDate, "error", err)
+		} else if expiration := d.AddDate(0, 0, 1); expiration.Before(end) {
+			end = expiration
+		}
+	}
+
+	// Runs are only counted from the start date when they can expire, otherwise days
+	// before the requested range are skipped
+	first := start
+	if s.ExpireRuns == nil || !hasStart {
+		if skipTo := from.Add(-duration).In(location); skipTo.After(first) {
+			first = time.Date(skipTo.Year(), skipTo.Month(), skipTo.Day(), 0, 0, 0, 0, location)
+		}
+	}
+
+	runs := int32(0)
+	for day := first; day.Before(end); day = time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, location) {
+		if !scheduleRunsOn(s, start, day) {
+			continue
+		}
+		runs++
+		if s.ExpireRuns != nil && runs > *s.ExpireRuns {
+			break
+		}
+		windowStart := time.Date(day.Year(), day.Month(), day.Day(), int(s.Hour), int(s.MinuteOfTheHour), 0, 0, location)
+		windowEnd := windowStart.Add(duration)
+		if windowStart.Before(to) && windowEnd.After(from) {
+			windows = append(windows, maintenanceWindow{Start: windowStart, End: windowEnd})
+		}
+		if s.ScheduleType == api.ScheduleScheduleTypeONCE {
+			break
+		}
+	}
+	return windows
+}
+
+// scheduleRunsOn reports whether the schedule triggers on the given day.
+func scheduleRunsOn(s api.Schedule, start time.Time, day time.Time) bool {
+	recurrence := 1
+	if s.Recurrence != nil && *s.Recurrence > 0 {
+		recurrence = int(*s.Recurrence)
+	}
+	switch s.ScheduleType {
+	case api.ScheduleScheduleTypeONCE:
+		return day.Equal(start)
+	case api.ScheduleScheduleTypeDAILY:
+		return daysBetween(start, day)%recurrence == 0
+	case api.ScheduleScheduleTypeWEEKLY:
+		weeks := daysBetween(startOfWeek(start), startOfWeek(day)) / 7
+		return weeks%recurrence == 0 && runsOnWeekday(s.DaysOfTheWeek, day)
+	case api.ScheduleScheduleTypeMONTHLY:
+		return runsInMonth(s, day) && runsOnDayOfMonth(s, day)
+	}
+	return false
+}
+
+func daysBetween(from time.Time, to time.Time) int {
+	a := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	b := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(b.Sub(a).Hours() / 24)
+}
+
+func startOfWeek(day time.Time) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day()-int(day.Weekday()), 0, 0, 0, 0, day.Location())
+}
+
+func runsOnWeekday(days *[]api.ScheduleDaysOfTheWeek, day time.Time) bool {
+	if days == nil {
+		return false
+	}
+	for _, d := range *days {
+		if strings.EqualFold(string(d), day.Weekday().String()) {
+			return true
+		}
+	}
+	return false
+}
+
+func runsInMonth(s api.Schedule, day time.Time) bool {
+	if s.Months != nil && len(*s.Months) > 0 {
+		for _, m := range *s.Months {
+			if int(m) == int(day.Month()) {
+				return true
+			}
+		}
+		return false
+	}
+	if s.Month != nil {
+		return int(*s.Month) == int(day.Month())
+	}
+	return true
+}
+
+func runsOnDayOfMonth(s api.Schedule, day time.Time) bool {
+	lastDay := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, day.Location()).Day()
+	if s.DaysOfTheMonth != nil && len(*s.DaysOfTheMonth) > 0 {
+		for _, d := range *s.DaysOfTheMonth {
+			if strings.EqualFold(d, "LAST") {
+				if day.Day() == lastDay {
+					return true
+				}
+				continue
+			}
+			n, err := strconv.Atoi(d)
+			if err == nil && n == day.Day() {
+				return true
+			}
+		}
+		return false
+	}
+	if s.DayOfTheMonth != nil {
+		return int(*s.DayOfTheMonth) == day.Day()
+	}
+	if s.WeeksOfTheMonth != nil && len(*s.WeeksOfTheMonth) > 0 {
+		if !runsOnWeekday(s.DaysOfTheWeek, day) {
+			return false
+		}
+		for _, w := range *s.WeeksOfTheMonth {
+			switch w {
+			case api.FIRST, api.SECOND, api.THIRD, api.FOURTH:
+				if weekOfMonth[w] == (day.Day()-1)/7+1 {
+					return true
+				}
+			case api.LAST:
+				if day.Day()+7 > lastDay {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+var weekOfMonth = map[api.ScheduleWeeksOfTheMonth]int{
+	api.FIRST:  1,
+	api.SECOND: 2,
+	api.THIRD:  3,
+	api.FOURTH: 4,
+}
+
+// scheduleDescription returns a human readable summary of a schedule used as annotation text.
+func scheduleDescription(s api.Schedule) string {
+	description := fmt.Sprintf("%s maintenance for %d minutes", strings.ToLower(string(s.ScheduleType)), s.Duration)
+	if s.TimeZone != nil && *s.TimeZone != "" {
+		description = fmt.Sprintf("%s (%s)", description, *s.TimeZone)
+	}
+	return description
+}
+
+// isMaintained reports whether any adapter instance reports the resource in maintenance.
+func isMaintained(resource api.Resource) bool {
+	for _, state := range resource.ResourceStatusStates {
+		if state.ResourceState == nil {
+			continue
+		}
+		switch *state.ResourceState {
+		case api.ResourceStatusStateResourceStateMAINTAINED, api.ResourceStatusStateResourceStateMAINTAINEDMANUAL:
+			return true
+		}
+	}
+	return false
+}
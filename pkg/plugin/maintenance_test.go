@@ -0,0 +1,87 @@
+package plugin
+
+import (
+	"swisscom-vmwareariaoperations-datasource/pkg/api"
+	"testing"
+	"time"
+)
+
+func TestScheduleWindows(t *testing.T) {
+	three := int32(3)
+	two := int32(2)
+	startDate := "10/01/2026"
+	days := []api.ScheduleDaysOfTheWeek{"MONDAY"}
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 0, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name     string
+		schedule api.Schedule
+		from     time.Time
+		to       time.Time
+		starts   []time.Time
+	}{
+		{
+			name:     "daily every third day from start date",
+			schedule: api.Schedule{ScheduleType: api.ScheduleScheduleTypeDAILY, StartDate: &startDate, Recurrence: &three, Hour: 2, Duration: 60},
+			from:     day(1),
+			to:       day(10),
+			starts:   []time.Time{day(1).Add(2 * time.Hour), day(4).Add(2 * time.Hour), day(7).Add(2 * time.Hour)},
+		},
+		{
+			name:     "once needs a start date",
+			schedule: api.Schedule{ScheduleType: api.ScheduleScheduleTypeONCE, Duration: 60},
+			from:     day(1),
+			to:       day(10),
+		},
+		{
+			name:     "weekly on mondays every second week from start date",
+			schedule: api.Schedule{ScheduleType: api.ScheduleScheduleTypeWEEKLY, StartDate: &startDate, Recurrence: &two, DaysOfTheWeek: &days, Duration: 30},
+			from:     day(1),
+			to:       day(31),
+			starts:   []time.Time{day(12), day(26)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			windows := scheduleWindows(tt.schedule, tt.from, tt.to)
+			if len(windows) != len(tt.starts) {
+				t.Fatalf("expected %d windows, got %v", len(tt.starts), windows)
+			}
+			for i, w := range windows {
+				if !w.Start.Equal(tt.starts[i]) {
+					t.Errorf("window %d starts at %v, expected %v", i, w.Start, tt.starts[i])
+				}
+			}
+		})
+	}
+}
+
+// Recurring schedules without start date must not move when the requested range changes
+func TestScheduleWindowsWithoutStartDateIgnoreRange(t *testing.T) {
+	three := int32(3)
+	two := int32(2)
+	days := []api.ScheduleDaysOfTheWeek{"MONDAY", "THURSDAY"}
+	schedules := map[string]api.Schedule{
+		"daily":  {ScheduleType: api.ScheduleScheduleTypeDAILY, Recurrence: &three, Hour: 1, Duration: 60},
+		"weekly": {ScheduleType: api.ScheduleScheduleTypeWEEKLY, Recurrence: &two, DaysOfTheWeek: &days, Duration: 60},
+	}
+	to := time.Date(2026, 11, 30, 0, 0, 0, 0, time.UTC)
+	for name, schedule := range schedules {
+		t.Run(name, func(t *testing.T) {
+			reference := map[time.Time]bool{}
+			for _, w := range scheduleWindows(schedule, time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC), to) {
+				reference[w.Start] = true
+			}
+			if len(reference) == 0 {
+				t.Fatal("expected windows")
+			}
+			for shift := 1; shift < 7; shift++ {
+				from := time.Date(2026, 11, 1+shift, 0, 0, 0, 0, time.UTC)
+				for _, w := range scheduleWindows(schedule, from, to) {
+					if !reference[w.Start] {
+						t.Errorf("range starting %v has window %v which the reference range does not have", from, w.Start)
+					}
+				}
+			}
+		})
+	}
+}
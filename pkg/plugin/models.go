@@ -1,8 +1,12 @@
 package plugin
 
 import (
+	"encoding/json"
+	"strconv"
 	"swisscom-vmwareariaoperations-datasource/pkg/api"
 	"time"
+
+	"github.com/oapi-codegen/runtime/types"
 )
 
 type queryModel struct {
@@ -15,26 +19,82 @@ type QueryBuilderOptions struct {
 	Collectors    Collectors      `json:"collectors,omitempty"`
 	CustomFilters []CustomFilters `json:"customFilters,omitempty"`
 	QueryType     QueryType       `json:"queryType,omitempty"`
+	Aggregation   Aggregation     `json:"aggregation,omitempty"`
+	// Formula is an Aria super metric formula evaluated by the plugin for every resource of the query
+	Formula string `json:"formula,omitempty"`
+	// Expression combines the metrics of the query, e.g. mem|consumed / mem|guest_provisioned * 100
+	Expression string `json:"expression,omitempty"`
+	// SeriesFunctions are applied in order to every metric series
+	SeriesFunctions []SeriesFunction `json:"seriesFunctions,omitempty"`
+	Forecast        Forecast         `json:"forecast,omitempty"`
+	// TimeShift adds the series of the query shifted by each duration, e.g. 1d or 1w
+	TimeShift []string `json:"timeShift,omitempty"`
+	// DisableDownsampling returns all points instead of reducing series to the max data points of the panel
+	DisableDownsampling bool `json:"disableDownsampling,omitempty"`
 }
 
 type QueryType string
 
 const (
-	TimeSeries QueryType = "timeSeries"
-	Table      QueryType = "table"
+	TimeSeries  QueryType = "timeSeries"
+	Table       QueryType = "table"
+	Platform    QueryType = "platformHealth"
+	Collector   QueryType = "collectors"
+	Adapter     QueryType = "adapterInstances"
+	Maintenance QueryType = "maintenance"
+	Formula     QueryType = "formula"
 )
 
 type Functions struct {
 	AdapterKind  string `json:"adapterKind,omitempty"`
 	ResourceKind string `json:"resourceKind,omitempty"`
 	WithMetric   string `json:"withMetric,omitempty"`
+	// WithSuperMetric selects a super metric by name, it is queried as sm_<id> stat key
+	WithSuperMetric string `json:"withSuperMetric,omitempty"`
+	// AdapterInstanceId limits adapter instance queries to a single instance
+	AdapterInstanceId string `json:"adapterInstanceId,omitempty"`
 }
 
 type Filters struct {
-	WhereHealth []api.ResourceQueryResourceHealth `json:"whereHealth,omitempty"`
-	WhereState  []api.ResourceQueryResourceState  `json:"whereState,omitempty"`
-	WhereStatus []api.ResourceQueryResourceStatus `json:"whereStatus,omitempty"`
-	WhereTag    []string                          `json:"whereTag,omitempty"`
+	WhereHealth  []api.ResourceQueryResourceHealth `json:"whereHealth,omitempty"`
+	WhereState   []api.ResourceQueryResourceState  `json:"whereState,omitempty"`
+	WhereStatus  []api.ResourceQueryResourceStatus `json:"whereStatus,omitempty"`
+	WhereTag     []string                          `json:"whereTag,omitempty"`
+	WhereService []string                          `json:"whereService,omitempty"`
+	// ExcludeMaintained skips resources which are currently in maintenance
+	ExcludeMaintained bool `json:"excludeMaintained,omitempty"`
+}
+
+// Aggregation combines the series of a query per group of label values
+type Aggregation struct {
+	Operator string `json:"operator,omitempty"`
+	// GroupBy are labels of the series, properties listed here label the series without being selected
+	GroupBy []string `json:"groupBy,omitempty"`
+	// Percentile between 0 and 100, only used by the percentile operator
+	Percentile float64 `json:"percentile,omitempty"`
+}
+
+// SeriesFunction transforms a metric series, e.g. into the rate of a counter
+type SeriesFunction struct {
+	Function string `json:"function,omitempty"`
+	// Window is a duration such as 30m or 1d, used by moving window functions and exponential smoothing
+	Window string `json:"window,omitempty"`
+	// Alpha is the smoothing factor per rollup interval of exponential smoothing
+	Alpha float64 `json:"alpha,omitempty"`
+}
+
+// Forecast predicts the series of a query for a horizon such as 30d
+type Forecast struct {
+	Model   string `json:"model,omitempty"`
+	Horizon string `json:"horizon,omitempty"`
+	// Season is the length of a Holt-Winters season, one day by default
+	Season string `json:"season,omitempty"`
+	// Confidence of the predicted bounds between 0 and 1, 0.95 by default
+	Confidence float64 `json:"confidence,omitempty"`
+	// Output selects the frames of the response, the series with their forecast by default
+	Output string `json:"output,omitempty"`
+	// Threshold of the time until threshold output
+	Threshold *float64 `json:"threshold,omitempty"`
 }
 
 type CustomFilters struct {
@@ -66,7 +126,106 @@ type timeData struct {
 	Data      []float64
 }
 
+type platformHealth struct {
+	Node     *api.NodeStatus
+	Services []api.Service
+}
+
+// ariaTime is a timestamp which Aria serializes as epoch milliseconds even though
+// the API specification declares it as date-time string. Both forms are accepted.
+type ariaTime struct {
+	time.Time
+}
+
+func (t *ariaTime) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	millis, err := strconv.ParseInt(string(b), 10, 64)
+	if err == nil {
+		t.Time = time.UnixMilli(millis)
+		return nil
+	}
+	return json.Unmarshal(b, &t.Time)
+}
+
+type collector struct {
+	api.Collector
+	LastHeartbeat *ariaTime `json:"lastHeartbeat,omitempty"`
+}
+
+type collectors struct {
+	Collector []collector `json:"collector,omitempty"`
+}
+
+type adapterInstance struct {
+	api.AdapterInstance
+	LastCollected *ariaTime `json:"lastCollected,omitempty"`
+	LastHeartbeat *ariaTime `json:"lastHeartbeat,omitempty"`
+}
+
+type adapterInstances struct {
+	AdapterInstancesInfoDto []adapterInstance `json:"adapterInstancesInfoDto,omitempty"`
+}
+
+type superMetric struct {
+	api.Supermetric
+	ModificationTime *ariaTime `json:"modificationTime,omitempty"`
+}
+
+type superMetrics struct {
+	PageInfo     *api.PageInfo `json:"pageInfo,omitempty"`
+	SuperMetrics []superMetric `json:"superMetrics,omitempty"`
+}
+
+type SuperMetricResponse struct {
+	Id          string  `json:"id"`
+	Key         string  `json:"key"`
+	Name        string  `json:"name"`
+	Description *string `json:"description,omitempty"`
+	Formula     string  `json:"formula"`
+}
+
+type collectorHealth struct {
+	Collector collector
+	Groups    []string
+	Adapters  []adapterInstance
+}
+
 type Tags struct {
 	Name  string `json:"category"`
 	Value string `json:"name"`
 }
+
+type AlertAction string
+
+const (
+	TakeOwnership    AlertAction = "takeownership"
+	ReleaseOwnership AlertAction = "releaseownership"
+	Suspend          AlertAction = "suspend"
+	Cancel           AlertAction = "cancel"
+)
+
+type AlertActionRequest struct {
+	Action   AlertAction  `json:"action"`
+	AlertIds []types.UUID `json:"alertIds"`
+	Minutes  *int32       `json:"minutes,omitempty"`
+}
+
+type MaintenanceRequest struct {
+	BuilderOptions QueryBuilderOptions `json:"builderOptions"`
+	// Duration of the maintenance in minutes, only used when starting maintenance
+	Duration int32 `json:"duration,omitempty"`
+	// From and To (epoch milliseconds) define the range used to evaluate tag filters
+	From int64 `json:"from,omitempty"`
+	To   int64 `json:"to,omitempty"`
+}
+
+type MaintenanceResponse struct {
+	ResourceIds []types.UUID `json:"resourceIds"`
+}
+
+type AlertNoteRequest struct {
+	AlertId types.UUID `json:"alertId"`
+	Content string     `json:"content"`
+}
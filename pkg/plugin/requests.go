@@ -3,17 +3,32 @@ package plugin
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"swisscom-vmwareariaoperations-datasource/pkg/api"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/oapi-codegen/runtime/types"
 )
 
 var tagProperty = "summary|tagJson"
 
+// Labels of series which are not properties of the resource
+var builtinLabels = map[string]bool{"__name__": true, "adapterKind": true, "resourceKind": true, "resourceId": true, "resourceName": true, "offset": true}
+
+var errNoMetrics = errors.New("no metrics found matching query")
+
+// How long the stat key of a super metric is used before it is looked up again
+const superMetricKeyTTL = 10 * time.Minute
+
 func (d *Datasource) fetchProperties(ctx context.Context, q queryModel, resourceIds *map[types.UUID]*api.ResourceKey, from time.Time, to time.Time) (*[]api.InternalResourcePropertyContents, error) {
 	fromMilli := from.UnixMilli()
 	toMilli := to.UnixMilli()
@@ -23,7 +38,7 @@ func (d *Datasource) fetchProperties(ctx context.Context, q queryModel, resource
 	}
 	body := api.QueryPropertyChangesOfResourcesUsingPOSTJSONRequestBody{
 		ResourceId:  resourceIdsSlice,
-		PropertyKey: append(q.BuilderOptions.Collectors.WithProperty, tagProperty),
+		PropertyKey: propertyKeys(q),
 		Begin:       &fromMilli,
 		End:         &toMilli,
 	}
@@ -31,7 +46,7 @@ func (d *Datasource) fetchProperties(ctx context.Context, q queryModel, resource
 		XOpsAPIUseUnsupported: true,
 	}
 
-	resp, err := d.ariaClient.QueryPropertyChangesOfResourcesUsingPOSTWithResponse(ctx, &params, body)
+	resp, err := d.ariaClient.Load().QueryPropertyChangesOfResourcesUsingPOSTWithResponse(ctx, &params, body)
 	if err != nil {
 		return nil, err
 	}
@@ -41,6 +56,41 @@ func (d *Datasource) fetchProperties(ctx context.Context, q queryModel, resource
 	return nil, fmt.Errorf("no properties found matching query")
 }
 
+// propertyKeys lists the properties which label the series of the query. Besides the selected properties,
+// these are the tags and the properties the series are grouped by, which would otherwise be missing from
+// the labels and put every series into one group.
+func propertyKeys(q queryModel) []string {
+	keys := append([]string{}, q.BuilderOptions.Collectors.WithProperty...)
+	if q.BuilderOptions.Aggregation.Operator != "" {
+		for _, label := range q.BuilderOptions.Aggregation.GroupBy {
+			if !builtinLabels[label] && !strings.HasPrefix(label, "tag|") && !slices.Contains(keys, label) {
+				keys = append(keys, label)
+			}
+		}
+	}
+	return append(keys, tagProperty)
+}
+
+// fetchShiftedProperties retrieves the property changes for the range moved back by the offset, the
+// timestamps are moved forward again to match the shifted metrics
+func (d *Datasource) fetchShiftedProperties(ctx context.Context, q queryModel, resourceIds *map[types.UUID]*api.ResourceKey, from time.Time, to time.Time, offset time.Duration) (*[]api.InternalResourcePropertyContents, error) {
+	properties, err := d.fetchProperties(ctx, q, resourceIds, from.Add(-offset), to.Add(-offset))
+	if err != nil {
+		return nil, err
+	}
+	for _, resource := range *properties {
+		contents := resource.PropertyContents.PropertyContent
+		for i := range contents {
+			timestamps := make([]int64, len(contents[i].Timestamps))
+			for j, ts := range contents[i].Timestamps {
+				timestamps[j] = ts + offset.Milliseconds()
+			}
+			contents[i].Timestamps = timestamps
+		}
+	}
+	return properties, nil
+}
+
 func (d *Datasource) fetchMetrics(ctx context.Context, q queryModel, resourceIds *map[types.UUID]*api.ResourceKey, from time.Time, to time.Time) (*[]api.StatsOfResource, error) {
 	fromMilli := from.UnixMilli()
 	toMilli := to.UnixMilli()
@@ -48,21 +98,157 @@ func (d *Datasource) fetchMetrics(ctx context.Context, q queryModel, resourceIds
 	for resourceId := range *resourceIds {
 		resourceIdsSlice = append(resourceIdsSlice, resourceId)
 	}
+	statKeys := make([]string, 0)
+	if q.BuilderOptions.Functions.WithMetric != "" {
+		statKeys = append(statKeys, q.BuilderOptions.Functions.WithMetric)
+	}
+	if q.BuilderOptions.Functions.WithSuperMetric != "" {
+		key, err := d.superMetricKey(ctx, q.BuilderOptions.Functions.WithSuperMetric)
+		if err != nil {
+			return nil, err
+		}
+		statKeys = append(statKeys, key)
+	}
+	for _, key := range expressionMetrics(q.BuilderOptions.Expression) {
+		if !slices.Contains(statKeys, key) {
+			statKeys = append(statKeys, key)
+		}
+	}
 	body := api.GetStatsForResourcesUsingPOSTJSONRequestBody{
 		ResourceId: &resourceIdsSlice,
-		StatKey:    &[]string{q.BuilderOptions.Functions.WithMetric},
+		StatKey:    &statKeys,
 		Begin:      &fromMilli,
 		End:        &toMilli,
 	}
 
-	resp, err := d.ariaClient.GetStatsForResourcesUsingPOSTWithResponse(ctx, body)
+	resp, err := d.ariaClient.Load().GetStatsForResourcesUsingPOSTWithResponse(ctx, body)
 	if err != nil {
 		return nil, err
 	}
-	if resp.JSON200 != nil && resp.JSON200.Values != nil {
-		return resp.JSON200.Values, nil
+	if resp.JSON200 == nil {
+		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode(), string(resp.Body))
+	}
+	if resp.JSON200.Values == nil {
+		return nil, errNoMetrics
+	}
+	return resp.JSON200.Values, nil
+}
+
+// fetchShiftedMetrics retrieves metrics for the range moved back by the offset, the timestamps are moved
+// forward again so the series can be shown next to the current ones
+func (d *Datasource) fetchShiftedMetrics(ctx context.Context, q queryModel, resourceIds *map[types.UUID]*api.ResourceKey, from time.Time, to time.Time, offset time.Duration) (*[]api.StatsOfResource, error) {
+	metrics, err := d.fetchMetrics(ctx, q, resourceIds, from.Add(-offset), to.Add(-offset))
+	if err != nil {
+		return nil, err
+	}
+	for _, resource := range *metrics {
+		if resource.StatList == nil || resource.StatList.Stat == nil {
+			continue
+		}
+		stats := *resource.StatList.Stat
+		for i := range stats {
+			timestamps := make([]int64, len(stats[i].Timestamps))
+			for j, ts := range stats[i].Timestamps {
+				timestamps[j] = ts + offset.Milliseconds()
+			}
+			stats[i].Timestamps = timestamps
+		}
+	}
+	return metrics, nil
+}
+
+func (d *Datasource) fetchSuperMetrics(ctx context.Context, names []string) ([]superMetric, error) {
+	result := make([]superMetric, 0)
+	pageSize := int32(1000)
+	for page := int32(0); ; page++ {
+		params := api.GetSuperMetricsUsingGETParams{Page: &page, PageSize: &pageSize}
+		if len(names) > 0 {
+			params.Name = &names
+		}
+		var list superMetrics
+		resp, err := d.ariaClient.Load().GetSuperMetricsUsingGET(ctx, &params)
+		if err != nil {
+			return nil, err
+		}
+		err = decodeAriaResponse(resp, &list)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, list.SuperMetrics...)
+		pageInfo := list.PageInfo
+		if len(list.SuperMetrics) < int(pageSize) || pageInfo == nil || pageInfo.TotalCount == nil ||
+			int64(page+1)*int64(pageSize) >= int64(*pageInfo.TotalCount) {
+			break
+		}
+	}
+	return result, nil
+}
+
+// superMetricKey translates a super metric name into the stat key under which Aria stores its values.
+// Keys are cached, so metric queries do not look up the super metric every time.
+func (d *Datasource) superMetricKey(ctx context.Context, name string) (string, error) {
+	cacheKey := d.superMetricCacheKey(ctx, name)
+	if key, ok := d.superMetricKeys.get(cacheKey); ok {
+		return key, nil
+	}
+	list, err := d.fetchSuperMetrics(ctx, []string{name})
+	if err != nil {
+		return "", err
+	}
+	for _, sm := range list {
+		// The name filter of Aria is not guaranteed to be an exact match
+		if sm.Name == name && sm.Id != nil {
+			key := superMetricStatKey(*sm.Id)
+			d.superMetricKeys.put(cacheKey, key)
+			return key, nil
+		}
+	}
+	return "", fmt.Errorf("super metric %q not found", name)
+}
+
+// superMetricCacheKey scopes cached keys to the Grafana user when their identity is forwarded, Aria only shows
+// users the super metrics they are allowed to see
+func (d *Datasource) superMetricCacheKey(ctx context.Context, name string) string {
+	identity := identityFromContext(ctx)
+	if d.users.Load() == nil || identity == nil {
+		return name
 	}
-	return nil, fmt.Errorf("no metrics found matching query")
+	return identity.key() + "\x00" + name
+}
+
+// superMetricKeyCache maps super metric names to their stat keys. Entries expire, so renamed or
+// recreated super metrics are picked up again.
+type superMetricKeyCache struct {
+	mu   sync.Mutex
+	keys map[string]superMetricKeyEntry
+}
+
+type superMetricKeyEntry struct {
+	key     string
+	expires time.Time
+}
+
+func (c *superMetricKeyCache) get(name string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.keys[name]
+	if !ok || time.Now().After(entry.expires) {
+		return "", false
+	}
+	return entry.key, true
+}
+
+func (c *superMetricKeyCache) put(name string, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.keys == nil {
+		c.keys = make(map[string]superMetricKeyEntry)
+	}
+	c.keys[name] = superMetricKeyEntry{key: key, expires: time.Now().Add(superMetricKeyTTL)}
+}
+
+func superMetricStatKey(id types.UUID) string {
+	return fmt.Sprintf("sm_%s", id.String())
 }
 
 func (d *Datasource) fetchResources(ctx context.Context, q queryModel) (map[types.UUID]*api.ResourceKey, error) {
@@ -85,13 +271,17 @@ func (d *Datasource) fetchResources(ctx context.Context, q queryModel) (map[type
 	//if q.BuilderOptions.Filters.WhereTag != nil {
 	//	body.ResourceTag = &q.BuilderOptions.Filters.WhereTag
 	//}
-	resp, err := d.ariaClient.GetMatchingResourcesUsingPOSTWithResponse(ctx, &params, body)
+	resp, err := d.ariaClient.Load().GetMatchingResourcesUsingPOSTWithResponse(ctx, &params, body)
 	if err != nil {
 		return nil, err
 	}
 	resourceIds := make(map[types.UUID]*api.ResourceKey)
 	if resp.JSON200 != nil && resp.JSON200.ResourceList != nil {
 		for _, resources := range *resp.JSON200.ResourceList {
+			if q.BuilderOptions.Filters.ExcludeMaintained && isMaintained(resources) {
+				backend.Logger.Debug("Skipping resource in maintenance", "resourceId", resources.Identifier)
+				continue
+			}
 			resourceIds[resources.Identifier] = &resources.ResourceKey
 		}
 		return resourceIds, nil
@@ -99,13 +289,176 @@ func (d *Datasource) fetchResources(ctx context.Context, q queryModel) (map[type
 	return nil, fmt.Errorf("no resources found matching query")
 }
 
+// fetchPlatformHealth retrieves the status of the Aria node serving the API together with its services.
+// When services are given only those are requested and aggregated into the node status.
+func (d *Datasource) fetchPlatformHealth(ctx context.Context, services []string) (*platformHealth, error) {
+	health := platformHealth{Services: make([]api.Service, 0)}
+
+	params := api.GetNodeStatusUsingGETParams{}
+	if len(services) > 0 {
+		params.Services = &services
+	}
+	nodeResp, err := d.ariaClient.Load().GetNodeStatusUsingGETWithResponse(ctx, &params)
+	if err != nil {
+		return nil, err
+	}
+	if nodeResp.StatusCode() != http.StatusOK || nodeResp.JSON200 == nil {
+		return nil, fmt.Errorf("unexpected node status response %d: %s", nodeResp.StatusCode(), string(nodeResp.Body))
+	}
+	health.Node = nodeResp.JSON200
+
+	if len(services) == 0 {
+		servicesResp, err := d.ariaClient.Load().GetServicesInfoUsingGETWithResponse(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if servicesResp.StatusCode() != http.StatusOK {
+			return nil, fmt.Errorf("unexpected services response %d: %s", servicesResp.StatusCode(), string(servicesResp.Body))
+		}
+		if servicesResp.JSON200 != nil && servicesResp.JSON200.Service != nil {
+			health.Services = *servicesResp.JSON200.Service
+		}
+		return &health, nil
+	}
+
+	for _, service := range services {
+		serviceResp, err := d.ariaClient.Load().GetServiceInfoUsingGETWithResponse(ctx, api.GetServiceInfoUsingGETParamsName(service))
+		if err != nil {
+			return nil, err
+		}
+		if serviceResp.StatusCode() != http.StatusOK || serviceResp.JSON200 == nil {
+			backend.Logger.Warn("Unable to get service info", "service", service, "StatusCode", serviceResp.StatusCode(), "error", string(serviceResp.Body))
+			name := api.ServiceName(service)
+			details := string(serviceResp.Body)
+			health.Services = append(health.Services, api.Service{Name: &name, Health: api.ServiceHealthUNKNOWN, Details: &details})
+			continue
+		}
+		health.Services = append(health.Services, *serviceResp.JSON200)
+	}
+	return &health, nil
+}
+
+func (d *Datasource) fetchCollectorGroups(ctx context.Context) ([]api.CollectorGroup, error) {
+	resp, err := d.ariaClient.Load().GetCollectorGroupsUsingGETWithResponse(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode(), string(resp.Body))
+	}
+	if resp.JSON200 == nil || resp.JSON200.CollectorGroups == nil {
+		return make([]api.CollectorGroup, 0), nil
+	}
+	return *resp.JSON200.CollectorGroups, nil
+}
+
+func (d *Datasource) fetchCollectorList(ctx context.Context) ([]collector, error) {
+	var list collectors
+	resp, err := d.ariaClient.Load().GetCollectorsUsingGET(ctx, &api.GetCollectorsUsingGETParams{})
+	if err != nil {
+		return nil, err
+	}
+	err = decodeAriaResponse(resp, &list)
+	if err != nil {
+		return nil, err
+	}
+	return list.Collector, nil
+}
+
+// fetchCollectors retrieves all collectors together with the collector groups they belong to
+// and the adapter instances running on them.
+func (d *Datasource) fetchCollectors(ctx context.Context, groups []api.CollectorGroup) ([]collectorHealth, error) {
+	list, err := d.fetchCollectorList(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	groupsByCollector := make(map[string][]string)
+	for _, group := range groups {
+		if group.CollectorIds == nil {
+			continue
+		}
+		for _, collectorId := range *group.CollectorIds {
+			id := strconv.Itoa(int(collectorId))
+			groupsByCollector[id] = append(groupsByCollector[id], group.Name)
+		}
+	}
+
+	result := make([]collectorHealth, 0, len(list))
+	for _, c := range list {
+		var adapters adapterInstances
+		resp, err := d.ariaClient.Load().GetAdaptersOnCollectorUsingGET(ctx, c.Id)
+		if err == nil {
+			err = decodeAriaResponse(resp, &adapters)
+		}
+		if err != nil {
+			backend.Logger.Warn("Unable to get adapters on collector", "collectorId", c.Id, "error", err)
+		}
+		result = append(result, collectorHealth{
+			Collector: c,
+			Groups:    groupsByCollector[c.Id],
+			Adapters:  adapters.AdapterInstancesInfoDto,
+		})
+	}
+	return result, nil
+}
+
+// fetchAdapterInstances retrieves a single adapter instance when an id is given, otherwise all
+// adapter instances optionally limited to one adapter kind.
+func (d *Datasource) fetchAdapterInstances(ctx context.Context, adapterKind string, adapterInstanceId string) ([]adapterInstance, error) {
+	if adapterInstanceId != "" {
+		id, err := uuid.Parse(adapterInstanceId)
+		if err != nil {
+			return nil, fmt.Errorf("invalid adapter instance id %q: %w", adapterInstanceId, err)
+		}
+		var instance adapterInstance
+		resp, err := d.ariaClient.Load().GetAdapterInstanceUsingGET(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		err = decodeAriaResponse(resp, &instance)
+		if err != nil {
+			return nil, err
+		}
+		return []adapterInstance{instance}, nil
+	}
+
+	params := api.EnumerateAdapterInstancesUsingGETParams{}
+	if adapterKind != "" {
+		params.AdapterKindKey = &adapterKind
+	}
+	var instances adapterInstances
+	resp, err := d.ariaClient.Load().EnumerateAdapterInstancesUsingGET(ctx, &params)
+	if err != nil {
+		return nil, err
+	}
+	err = decodeAriaResponse(resp, &instances)
+	if err != nil {
+		return nil, err
+	}
+	return instances.AdapterInstancesInfoDto, nil
+}
+
+func (d *Datasource) fetchMaintenanceSchedules(ctx context.Context) ([]api.MaintenanceSchedule, error) {
+	resp, err := d.ariaClient.Load().GetMaintenanceSchedulesUsingGETWithResponse(ctx, &api.GetMaintenanceSchedulesUsingGETParams{})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode(), string(resp.Body))
+	}
+	if resp.JSON200 == nil || resp.JSON200.Schedules == nil {
+		return make([]api.MaintenanceSchedule, 0), nil
+	}
+	return *resp.JSON200.Schedules, nil
+}
+
 func (d *Datasource) fetchAdapterKinds(rw http.ResponseWriter, req *http.Request) {
 	rw.Header().Add("Content-Type", "application/json")
 
-	resp, err := d.ariaClient.GetAdapterTypesUsingGETWithResponse(req.Context(), &api.GetAdapterTypesUsingGETParams{})
+	resp, err := d.ariaClient.Load().GetAdapterTypesUsingGETWithResponse(req.Context(), &api.GetAdapterTypesUsingGETParams{})
 	if err != nil {
 		backend.Logger.Error("Unable to get adapter types", "error", err)
-		d.ariaClient = nil
 		return
 	}
 	adapterKinds := make(map[string]*[]string)
@@ -125,6 +478,30 @@ func (d *Datasource) fetchAdapterKinds(rw http.ResponseWriter, req *http.Request
 	rw.WriteHeader(http.StatusOK)
 }
 
+func (d *Datasource) fetchSuperMetricsList(rw http.ResponseWriter, req *http.Request) {
+	list, err := d.fetchSuperMetrics(req.Context(), nil)
+	if err != nil {
+		backend.Logger.Error("Unable to get super metrics", "error", err)
+		http.Error(rw, fmt.Sprintf("Unable to get super metrics: %s", err), http.StatusBadGateway)
+		return
+	}
+	superMetrics := make([]SuperMetricResponse, 0, len(list))
+	for _, sm := range list {
+		if sm.Id == nil {
+			continue
+		}
+		superMetrics = append(superMetrics, SuperMetricResponse{
+			Id:          sm.Id.String(),
+			Key:         superMetricStatKey(*sm.Id),
+			Name:        sm.Name,
+			Description: sm.Description,
+			Formula:     sm.Formula,
+		})
+	}
+	sort.Slice(superMetrics, func(i, j int) bool { return superMetrics[i].Name < superMetrics[j].Name })
+	writeJSON(rw, http.StatusOK, superMetrics)
+}
+
 func (d *Datasource) fetchMetricsProperties(rw http.ResponseWriter, req *http.Request) {
 	adapterKind := req.URL.Query().Get("adapterKind")
 	resourceKind := req.URL.Query().Get("resourceKind")
@@ -139,10 +516,9 @@ func (d *Datasource) fetchMetricsProperties(rw http.ResponseWriter, req *http.Re
 	properties := make([]string, 0)
 
 	statsOnly := false
-	response, err := d.ariaClient.GetResourceTypeAttributesForAdapterTypeUsingGETWithResponse(req.Context(), adapterKind, resourceKind, &api.GetResourceTypeAttributesForAdapterTypeUsingGETParams{StatOnly: &statsOnly})
+	response, err := d.ariaClient.Load().GetResourceTypeAttributesForAdapterTypeUsingGETWithResponse(req.Context(), adapterKind, resourceKind, &api.GetResourceTypeAttributesForAdapterTypeUsingGETParams{StatOnly: &statsOnly})
 	if err != nil {
 		backend.Logger.Error("Unable to get metrics and properties", "adapterKind", &adapterKind, "resourceKind", &resourceKind, "error", err)
-		d.ariaClient = nil
 		rw.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -170,3 +546,392 @@ func (d *Datasource) fetchMetricsProperties(rw http.ResponseWriter, req *http.Re
 	}
 	rw.WriteHeader(http.StatusOK)
 }
+
+func (d *Datasource) modifyAlerts(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var actionRequest AlertActionRequest
+	err := json.NewDecoder(req.Body).Decode(&actionRequest)
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("Unable to decode request: %s", err), http.StatusBadRequest)
+		return
+	}
+	if len(actionRequest.AlertIds) == 0 {
+		http.Error(rw, "Missing alertIds", http.StatusBadRequest)
+		return
+	}
+	params := api.ModifyAlertsUsingPOSTParams{Action: string(actionRequest.Action)}
+	switch actionRequest.Action {
+	case TakeOwnership, ReleaseOwnership, Cancel:
+	case Suspend:
+		if actionRequest.Minutes == nil || *actionRequest.Minutes <= 0 {
+			http.Error(rw, "Suspend requires a positive number of minutes", http.StatusBadRequest)
+			return
+		}
+		params.Minutes = actionRequest.Minutes
+	default:
+		http.Error(rw, fmt.Sprintf("Unsupported alert action %q", actionRequest.Action), http.StatusBadRequest)
+		return
+	}
+
+	backend.Logger.Debug("Modifying alerts", "action", actionRequest.Action, "alertIds", actionRequest.AlertIds)
+	resp, err := d.ariaClient.Load().ModifyAlertsUsingPOSTWithResponse(req.Context(), &params, api.ModifyAlertsUsingPOSTJSONRequestBody{Uuids: &actionRequest.AlertIds})
+	if err != nil {
+		backend.Logger.Error("Unable to modify alerts", "action", actionRequest.Action, "error", err)
+		http.Error(rw, fmt.Sprintf("Unable to modify alerts: %s", err), http.StatusBadGateway)
+		return
+	}
+	if resp.StatusCode() != http.StatusOK {
+		writeAriaError(rw, fmt.Sprintf("%s alerts", actionRequest.Action), resp.StatusCode(), resp.Body)
+		return
+	}
+	writeJSON(rw, http.StatusOK, resp.JSON200)
+}
+
+func (d *Datasource) addAlertNote(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var noteRequest AlertNoteRequest
+	err := json.NewDecoder(req.Body).Decode(&noteRequest)
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("Unable to decode request: %s", err), http.StatusBadRequest)
+		return
+	}
+	if noteRequest.Content == "" {
+		http.Error(rw, "Missing note content", http.StatusBadRequest)
+		return
+	}
+
+	backend.Logger.Debug("Adding alert note", "alertId", noteRequest.AlertId)
+	resp, err := d.ariaClient.Load().AddAlertNoteUsingPOSTWithResponse(req.Context(), noteRequest.AlertId, api.AddAlertNoteUsingPOSTJSONRequestBody{Content: noteRequest.Content})
+	if err != nil {
+		backend.Logger.Error("Unable to add alert note", "alertId", noteRequest.AlertId, "error", err)
+		http.Error(rw, fmt.Sprintf("Unable to add alert note: %s", err), http.StatusBadGateway)
+		return
+	}
+	if resp.StatusCode() != http.StatusCreated {
+		writeAriaError(rw, "add alert note", resp.StatusCode(), resp.Body)
+		return
+	}
+	writeJSON(rw, http.StatusCreated, resp.JSON201)
+}
+
+// selectResources resolves the resources a query would return, including the custom filters
+// which are otherwise only applied while building frames.
+func (d *Datasource) selectResources(ctx context.Context, q queryModel, from time.Time, to time.Time) ([]types.UUID, error) {
+	resourceIds, err := d.fetchResources(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	selected := make([]types.UUID, 0, len(resourceIds))
+	if len(q.BuilderOptions.CustomFilters) == 0 {
+		for resourceId := range resourceIds {
+			selected = append(selected, resourceId)
+		}
+		return selected, nil
+	}
+
+	// Tag filters need the tags of every resource, which are only available as property
+	tagsOnly := q
+	tagsOnly.BuilderOptions.Collectors.WithProperty = nil
+	properties, err := d.fetchProperties(ctx, tagsOnly, &resourceIds, from, to)
+	if err != nil {
+		backend.Logger.Warn("Unable to fetch tags, filtering by name only", "error", err)
+	}
+	tagsByResource := make(map[types.UUID][]Tags)
+	if properties != nil {
+		for _, property := range *properties {
+			for _, p := range property.PropertyContents.PropertyContent {
+				if p.StatKey != tagProperty || p.Values == nil || len(*p.Values) == 0 {
+					continue
+				}
+				// The last value is the one currently assigned to the resource
+				values := *p.Values
+				value := values[len(values)-1]
+				if value == "none" {
+					continue
+				}
+				var tags []Tags
+				err := json.Unmarshal([]byte(value), &tags)
+				if err != nil {
+					backend.Logger.Error("Was not able to parse tags", "tags", value, "error", err.Error())
+					continue
+				}
+				tagsByResource[property.ResourceId] = tags
+			}
+		}
+	}
+	for resourceId, meta := range resourceIds {
+		if filter(q.BuilderOptions.CustomFilters, meta.Name, tagsByResource[resourceId]) {
+			selected = append(selected, resourceId)
+		}
+	}
+	return selected, nil
+}
+
+func (d *Datasource) decodeMaintenanceRequest(rw http.ResponseWriter, req *http.Request) (*MaintenanceRequest, []types.UUID, bool) {
+	if req.Method != http.MethodPost {
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil, nil, false
+	}
+	var maintenanceRequest MaintenanceRequest
+	err := json.NewDecoder(req.Body).Decode(&maintenanceRequest)
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("Unable to decode request: %s", err), http.StatusBadRequest)
+		return nil, nil, false
+	}
+	functions := maintenanceRequest.BuilderOptions.Functions
+	if functions.AdapterKind == "" && functions.ResourceKind == "" {
+		// Never put the whole inventory into maintenance by accident
+		http.Error(rw, "Missing adapterKind or resourceKind", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	to := time.Now()
+	if maintenanceRequest.To != 0 {
+		to = time.UnixMilli(maintenanceRequest.To)
+	}
+	from := to.Add(-24 * time.Hour)
+	if maintenanceRequest.From != 0 {
+		from = time.UnixMilli(maintenanceRequest.From)
+	}
+	// Maintenance is started and ended for resources regardless of whether they are in maintenance already
+	options := maintenanceRequest.BuilderOptions
+	options.Filters.ExcludeMaintained = false
+	resourceIds, err := d.selectResources(req.Context(), queryModel{BuilderOptions: options}, from, to)
+	if err != nil {
+		backend.Logger.Error("Unable to get resourceIds", "error", err)
+		http.Error(rw, fmt.Sprintf("Unable to get resources: %s", err), http.StatusBadGateway)
+		return nil, nil, false
+	}
+	if len(resourceIds) == 0 {
+		http.Error(rw, "No resources match the query", http.StatusNotFound)
+		return nil, nil, false
+	}
+	return &maintenanceRequest, resourceIds, true
+}
+
+func (d *Datasource) startMaintenance(rw http.ResponseWriter, req *http.Request) {
+	maintenanceRequest, resourceIds, ok := d.decodeMaintenanceRequest(rw, req)
+	if !ok {
+		return
+	}
+	if maintenanceRequest.Duration <= 0 {
+		http.Error(rw, "Maintenance requires a positive duration in minutes", http.StatusBadRequest)
+		return
+	}
+
+	backend.Logger.Info("Starting maintenance", "resources", len(resourceIds), "duration", maintenanceRequest.Duration)
+	params := api.MarkResourcesAsBeingMaintainedUsingPUTParams{Id: resourceIds, Duration: &maintenanceRequest.Duration}
+	resp, err := d.ariaClient.Load().MarkResourcesAsBeingMaintainedUsingPUTWithResponse(req.Context(), &params)
+	if err != nil {
+		backend.Logger.Error("Unable to start maintenance", "error", err)
+		http.Error(rw, fmt.Sprintf("Unable to start maintenance: %s", err), http.StatusBadGateway)
+		return
+	}
+	if resp.StatusCode() != http.StatusOK {
+		writeAriaError(rw, "start maintenance", resp.StatusCode(), resp.Body)
+		return
+	}
+	writeJSON(rw, http.StatusOK, MaintenanceResponse{ResourceIds: resourceIds})
+}
+
+func (d *Datasource) endMaintenance(rw http.ResponseWriter, req *http.Request) {
+	_, resourceIds, ok := d.decodeMaintenanceRequest(rw, req)
+	if !ok {
+		return
+	}
+
+	backend.Logger.Info("Ending maintenance", "resources", len(resourceIds))
+	params := api.UnmarkResourcesAsBeingMaintainedUsingDELETEParams{Id: resourceIds}
+	resp, err := d.ariaClient.Load().UnmarkResourcesAsBeingMaintainedUsingDELETEWithResponse(req.Context(), &params)
+	if err != nil {
+		backend.Logger.Error("Unable to end maintenance", "error", err)
+		http.Error(rw, fmt.Sprintf("Unable to end maintenance: %s", err), http.StatusBadGateway)
+		return
+	}
+	if resp.StatusCode() != http.StatusOK {
+		writeAriaError(rw, "end maintenance", resp.StatusCode(), resp.Body)
+		return
+	}
+	writeJSON(rw, http.StatusOK, MaintenanceResponse{ResourceIds: resourceIds})
+}
+
+// fetchFormulaData retrieves the related resources and metrics referenced by a super metric formula.
+func (d *Datasource) fetchFormulaData(ctx context.Context, refs []formulaRef, resourceIds map[types.UUID]*api.ResourceKey, from time.Time, to time.Time) (*formulaData, error) {
+	fd := &formulaData{
+		Stats:   make(map[types.UUID]map[string]series),
+		Related: make(map[string]map[types.UUID][]types.UUID),
+		Named:   make(map[string][]types.UUID),
+	}
+	thisIds := make([]types.UUID, 0, len(resourceIds))
+	for resourceId := range resourceIds {
+		thisIds = append(thisIds, resourceId)
+	}
+
+	// Metric keys which are needed for each resource
+	metricsByResource := make(map[types.UUID]map[string]bool)
+	need := func(ids []types.UUID, metric string) {
+		for _, id := range ids {
+			if metricsByResource[id] == nil {
+				metricsByResource[id] = make(map[string]bool)
+			}
+			metricsByResource[id][metric] = true
+		}
+	}
+	for _, ref := range refs {
+		switch {
+		case ref.This:
+			need(thisIds, ref.Metric)
+		case ref.Name != "":
+			if _, ok := fd.Named[ref.Name]; !ok {
+				named, err := d.fetchNamedResources(ctx, ref)
+				if err != nil {
+					return nil, err
+				}
+				fd.Named[ref.Name] = named
+			}
+			need(fd.Named[ref.Name], ref.Metric)
+		default:
+			related, ok := fd.Related[ref.relationKey()]
+			if !ok {
+				var err error
+				related, err = d.fetchRelatedResources(ctx, thisIds, ref)
+				if err != nil {
+					return nil, err
+				}
+				fd.Related[ref.relationKey()] = related
+			}
+			for _, ids := range related {
+				need(ids, ref.Metric)
+			}
+		}
+	}
+
+	// Group resources by metric key so that every key is fetched once
+	resourcesByMetric := make(map[string]map[types.UUID]*api.ResourceKey)
+	for id, metrics := range metricsByResource {
+		for metric := range metrics {
+			if resourcesByMetric[metric] == nil {
+				resourcesByMetric[metric] = make(map[types.UUID]*api.ResourceKey)
+			}
+			resourcesByMetric[metric][id] = nil
+		}
+	}
+	for metric, ids := range resourcesByMetric {
+		q := queryModel{BuilderOptions: QueryBuilderOptions{Functions: Functions{WithMetric: metric}}}
+		stats, err := d.fetchMetrics(ctx, q, &ids, from, to)
+		if errors.Is(err, errNoMetrics) {
+			// Resources without data are left out of the formula
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to fetch metric %s: %w", metric, err)
+		}
+		for _, resourceStats := range *stats {
+			if resourceStats.ResourceId == nil || resourceStats.StatList == nil || resourceStats.StatList.Stat == nil {
+				continue
+			}
+			for _, stat := range *resourceStats.StatList.Stat {
+				if stat.Data == nil {
+					continue
+				}
+				s := make(series, len(stat.Timestamps))
+				for i, ts := range stat.Timestamps {
+					if i < len(*stat.Data) {
+						s[ts] = (*stat.Data)[i]
+					}
+				}
+				if fd.Stats[*resourceStats.ResourceId] == nil {
+					fd.Stats[*resourceStats.ResourceId] = make(map[string]series)
+				}
+				fd.Stats[*resourceStats.ResourceId][stat.StatKey.Key] = s.aligned(collectionInterval)
+			}
+		}
+	}
+	return fd, nil
+}
+
+// fetchRelatedResources returns the resources matching the reference which are related to each of the given resources.
+// A positive depth looks for descendants, a negative one for ancestors.
+func (d *Datasource) fetchRelatedResources(ctx context.Context, resourceIds []types.UUID, ref formulaRef) (map[types.UUID][]types.UUID, error) {
+	related := make(map[types.UUID][]types.UUID)
+	if len(resourceIds) == 0 {
+		return related, nil
+	}
+	body := api.GetResourcesRelationshipsUsingPOSTJSONRequestBody{
+		ResourceIds:   resourceIds,
+		ResourceQuery: &api.ResourceQuery{ResourceKind: &[]string{ref.ResourceKind}},
+	}
+	if ref.AdapterKind != "" {
+		body.ResourceQuery.AdapterKind = &[]string{ref.AdapterKind}
+	}
+	depth := int32(ref.Depth)
+	switch {
+	case depth == 1:
+		body.RelationshipType = api.ResourceRelationshipsQueryRelationshipTypeCHILD
+	case depth == -1:
+		body.RelationshipType = api.ResourceRelationshipsQueryRelationshipTypePARENT
+	case depth > 1:
+		body.RelationshipType = api.ResourceRelationshipsQueryRelationshipTypeDESCENDANT
+		body.HierarchyDepth = &depth
+	default:
+		body.RelationshipType = api.ResourceRelationshipsQueryRelationshipTypeANCESTOR
+		depth = -depth
+		body.HierarchyDepth = &depth
+	}
+
+	pageSize := int32(1000)
+	for page := int32(0); ; page++ {
+		params := api.GetResourcesRelationshipsUsingPOSTParams{Page: &page, PageSize: &pageSize}
+		resp, err := d.ariaClient.Load().GetResourcesRelationshipsUsingPOSTWithResponse(ctx, &params, body)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode() != http.StatusOK || resp.JSON200 == nil {
+			return nil, fmt.Errorf("unexpected relationships response %d: %s", resp.StatusCode(), string(resp.Body))
+		}
+		for _, relation := range resp.JSON200.ResourcesRelations {
+			for _, resourceId := range relation.RelatedResources {
+				related[resourceId] = append(related[resourceId], relation.Resource.Identifier)
+			}
+		}
+		pageInfo := resp.JSON200.PageInfo
+		if len(resp.JSON200.ResourcesRelations) < int(pageSize) || pageInfo == nil || pageInfo.TotalCount == nil ||
+			int64(page+1)*int64(pageSize) >= int64(*pageInfo.TotalCount) {
+			break
+		}
+	}
+	return related, nil
+}
+
+// fetchNamedResources returns the resources referenced by name in a formula
+func (d *Datasource) fetchNamedResources(ctx context.Context, ref formulaRef) ([]types.UUID, error) {
+	body := api.GetMatchingResourcesUsingPOSTJSONRequestBody{Name: &[]string{ref.Name}}
+	if ref.AdapterKind != "" {
+		body.AdapterKind = &[]string{ref.AdapterKind}
+	}
+	if ref.ResourceKind != "" {
+		body.ResourceKind = &[]string{ref.ResourceKind}
+	}
+	resp, err := d.ariaClient.Load().GetMatchingResourcesUsingPOSTWithResponse(ctx, &api.GetMatchingResourcesUsingPOSTParams{}, body)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]types.UUID, 0)
+	if resp.JSON200 != nil && resp.JSON200.ResourceList != nil {
+		for _, resource := range *resp.JSON200.ResourceList {
+			// Only resources with exactly this name are referenced
+			if resource.ResourceKey.Name == ref.Name {
+				ids = append(ids, resource.Identifier)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no resource named %q found", ref.Name)
+	}
+	return ids, nil
+}
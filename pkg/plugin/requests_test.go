@@ -0,0 +1,358 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"swisscom-vmwareariaoperations-datasource/pkg/api"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oapi-codegen/runtime/types"
+)
+
+// newAriaDatasource creates a datasource whose client sends requests to the handler
+func newAriaDatasource(t *testing.T, handler http.HandlerFunc) *Datasource {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	client, err := api.NewClientWithResponses(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Datasource{}
+	d.ariaClient.Store(client)
+	return d
+}
+
+// callHandler sends a request with the body to a resource handler of the datasource
+func callHandler(handler http.HandlerFunc, method string, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(method, "/", strings.NewReader(body)))
+	return rec
+}
+
+// newSuperMetricsDatasource serves total super metrics in pages of the requested size and counts the requests.
+func newSuperMetricsDatasource(t *testing.T, total int) (*Datasource, *atomic.Int32) {
+	var requests atomic.Int32
+	d := newAriaDatasource(t, func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/supermetrics" {
+			t.Errorf("unexpected request %s", req.URL.Path)
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		requests.Add(1)
+		page, _ := strconv.Atoi(req.URL.Query().Get("page"))
+		pageSize, _ := strconv.Atoi(req.URL.Query().Get("pageSize"))
+		superMetrics := make([]map[string]interface{}, 0)
+		for i := page * pageSize; i < min(total, (page+1)*pageSize); i++ {
+			superMetrics = append(superMetrics, map[string]interface{}{
+				"id":      uuid.NewSHA1(uuid.Nil, []byte(strconv.Itoa(i))).String(),
+				"name":    fmt.Sprintf("sm-%d", i),
+				"formula": "1",
+			})
+		}
+		writeJSON(rw, http.StatusOK, map[string]interface{}{
+			"pageInfo":     map[string]interface{}{"page": page, "pageSize": pageSize, "totalCount": total},
+			"superMetrics": superMetrics,
+		})
+	})
+	return d, &requests
+}
+
+func TestFetchSuperMetricsReadsAllPages(t *testing.T) {
+	tests := []struct {
+		total    int
+		requests int32
+	}{
+		{total: 0, requests: 1},
+		{total: 999, requests: 1},
+		{total: 1000, requests: 1},
+		{total: 2500, requests: 3},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.total), func(t *testing.T) {
+			d, requests := newSuperMetricsDatasource(t, tt.total)
+			list, err := d.fetchSuperMetrics(context.Background(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(list) != tt.total {
+				t.Errorf("expected %d super metrics, got %d", tt.total, len(list))
+			}
+			if requests.Load() != tt.requests {
+				t.Errorf("expected %d requests, got %d", tt.requests, requests.Load())
+			}
+		})
+	}
+}
+
+func TestSuperMetricKeyIsCached(t *testing.T) {
+	d, requests := newSuperMetricsDatasource(t, 1)
+	expected := superMetricStatKey(uuid.NewSHA1(uuid.Nil, []byte("0")))
+	for i := 0; i < 3; i++ {
+		key, err := d.superMetricKey(context.Background(), "sm-0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != expected {
+			t.Errorf("expected key %s, got %s", expected, key)
+		}
+	}
+	if requests.Load() != 1 {
+		t.Errorf("expected the key to be looked up once, got %d requests", requests.Load())
+	}
+	if _, err := d.superMetricKey(context.Background(), "unknown"); err == nil {
+		t.Error("expected an error for an unknown super metric")
+	}
+}
+
+func TestFetchShiftedPropertiesMovesTimestamps(t *testing.T) {
+	const day = int64(24 * 60 * 60 * 1000)
+	resourceId := uuid.New()
+	var begin, end int64
+	d := newAriaDatasource(t, func(rw http.ResponseWriter, req *http.Request) {
+		var body api.InternalPropertyChangeQuery
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		begin, end = *body.Begin, *body.End
+		writeJSON(rw, http.StatusOK, map[string]interface{}{"values": []interface{}{map[string]interface{}{
+			"resourceId": resourceId.String(),
+			"property-contents": map[string]interface{}{"property-content": []interface{}{map[string]interface{}{
+				"statKey":    "summary|version",
+				"timestamps": []int64{*body.Begin + 1000},
+				"values":     []string{"7.0"},
+			}}},
+		}}})
+	})
+
+	from, to := time.UnixMilli(10*day), time.UnixMilli(11*day)
+	properties, err := d.fetchShiftedProperties(context.Background(), queryModel{}, &map[types.UUID]*api.ResourceKey{resourceId: nil}, from, to, 7*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if begin != 3*day || end != 4*day {
+		t.Errorf("expected properties of the shifted range, got %d to %d", begin, end)
+	}
+	if ts := (*properties)[0].PropertyContents.PropertyContent[0].Timestamps[0]; ts != 10*day+1000 {
+		t.Errorf("expected the change to be moved into the requested range, got %d", ts)
+	}
+}
+
+func TestPropertyKeysIncludeGroupBy(t *testing.T) {
+	tests := []struct {
+		name         string
+		withProperty []string
+		aggregation  Aggregation
+		keys         []string
+	}{
+		{name: "selected properties", withProperty: []string{"summary|version"}, keys: []string{"summary|version", tagProperty}},
+		{
+			name:         "grouped by property",
+			withProperty: []string{"summary|version"},
+			aggregation:  Aggregation{Operator: AggregateSum, GroupBy: []string{"summary|parentCluster", "summary|version"}},
+			keys:         []string{"summary|version", "summary|parentCluster", tagProperty},
+		},
+		{
+			name:        "grouped by built-in labels and tags",
+			aggregation: Aggregation{Operator: AggregateAvg, GroupBy: []string{"resourceKind", "tag|env"}},
+			keys:        []string{tagProperty},
+		},
+		{name: "group by without aggregation", aggregation: Aggregation{GroupBy: []string{"summary|parentCluster"}}, keys: []string{tagProperty}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := queryModel{BuilderOptions: QueryBuilderOptions{Collectors: Collectors{WithProperty: tt.withProperty}, Aggregation: tt.aggregation}}
+			if keys := propertyKeys(q); !slices.Equal(keys, tt.keys) {
+				t.Errorf("expected %v, got %v", tt.keys, keys)
+			}
+		})
+	}
+}
+
+// newMaintenanceDatasource serves two virtual machines, vm-1 is in maintenance already. Aria answers
+// maintenance requests with the status and records the resources they were sent for.
+func newMaintenanceDatasource(t *testing.T, status int) (*Datasource, *[]string) {
+	var maintained []string
+	d := newAriaDatasource(t, func(rw http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.URL.Path == "/api/resources/query":
+			var body api.ResourceQuery
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				t.Error(err)
+			}
+			resources := make([]interface{}, 0)
+			if body.ResourceKind != nil && slices.Contains(*body.ResourceKind, "VirtualMachine") {
+				for i, state := range []string{"MAINTAINED", "STARTED"} {
+					resources = append(resources, map[string]interface{}{
+						"identifier":           uuid.NewSHA1(uuid.Nil, []byte(strconv.Itoa(i+1))).String(),
+						"resourceKey":          map[string]interface{}{"name": fmt.Sprintf("vm-%d", i+1), "adapterKindKey": "VMWARE", "resourceKindKey": "VirtualMachine"},
+						"resourceStatusStates": []interface{}{map[string]interface{}{"resourceState": state}},
+					})
+				}
+			}
+			writeJSON(rw, http.StatusOK, map[string]interface{}{"resourceList": resources})
+		case req.URL.Path == "/internal/resources/properties/query":
+			// The resources have no tags
+			writeJSON(rw, http.StatusOK, map[string]interface{}{"values": []interface{}{}})
+		case req.URL.Path == "/api/resources/maintained" && (req.Method == http.MethodPut || req.Method == http.MethodDelete):
+			maintained = append(maintained, req.Method)
+			maintained = append(maintained, req.URL.Query()["id"]...)
+			rw.WriteHeader(status)
+		default:
+			t.Errorf("unexpected request %s %s", req.Method, req.URL.Path)
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	})
+	return d, &maintained
+}
+
+func TestMaintenanceHandlers(t *testing.T) {
+	vm2 := uuid.NewSHA1(uuid.Nil, []byte("2")).String()
+	// Aria is asked for both machines in the order of their ids
+	both := []string{uuid.NewSHA1(uuid.Nil, []byte("1")).String(), vm2}
+	slices.Sort(both)
+	put := append([]string{http.MethodPut}, both...)
+	del := append([]string{http.MethodDelete}, both...)
+	tests := []struct {
+		name    string
+		end     bool
+		method  string
+		body    string
+		aria    int
+		status  int
+		request []string
+	}{
+		{name: "start", body: `{"builderOptions":{"functions":{"resourceKind":"VirtualMachine"}},"duration":30}`, status: http.StatusOK, request: put},
+		{name: "end", end: true, body: `{"builderOptions":{"functions":{"resourceKind":"VirtualMachine"}}}`, status: http.StatusOK, request: del},
+		{
+			name:    "end ignores the maintenance filter",
+			end:     true,
+			body:    `{"builderOptions":{"functions":{"resourceKind":"VirtualMachine"},"filters":{"excludeMaintained":true}}}`,
+			status:  http.StatusOK,
+			request: del,
+		},
+		{name: "start filtered by name", body: `{"builderOptions":{"functions":{"resourceKind":"VirtualMachine"},"customFilters":[{"type":"resourceName","operand":"=","value":"vm-2"}]},"duration":30}`, status: http.StatusOK, request: []string{http.MethodPut, vm2}},
+		{name: "start without duration", body: `{"builderOptions":{"functions":{"resourceKind":"VirtualMachine"}}}`, status: http.StatusBadRequest},
+		{name: "without resource kind", end: true, body: `{"builderOptions":{}}`, status: http.StatusBadRequest},
+		{name: "invalid body", body: `{`, status: http.StatusBadRequest},
+		{name: "wrong method", method: http.MethodGet, status: http.StatusMethodNotAllowed},
+		{name: "no matching resources", end: true, body: `{"builderOptions":{"functions":{"resourceKind":"HostSystem"}}}`, status: http.StatusNotFound},
+		{name: "denied by Aria", end: true, body: `{"builderOptions":{"functions":{"resourceKind":"VirtualMachine"}}}`, aria: http.StatusForbidden, status: http.StatusForbidden, request: del},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aria := tt.aria
+			if aria == 0 {
+				aria = http.StatusOK
+			}
+			d, maintained := newMaintenanceDatasource(t, aria)
+			handler := d.startMaintenance
+			if tt.end {
+				handler = d.endMaintenance
+			}
+			method := tt.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			rec := callHandler(handler, method, tt.body)
+			if rec.Code != tt.status {
+				t.Errorf("expected status %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+			if len(tt.request) > 0 {
+				slices.Sort((*maintained)[1:])
+			}
+			if !slices.Equal(*maintained, tt.request) {
+				t.Errorf("expected Aria to be asked %v, got %v", tt.request, *maintained)
+			}
+		})
+	}
+}
+
+// newAlertsDatasource answers alert actions and notes with the status and records the requests Aria received
+func newAlertsDatasource(t *testing.T, status int) (*Datasource, *[]string) {
+	var requests []string
+	d := newAriaDatasource(t, func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		requests = append(requests, fmt.Sprintf("%s %s %s", req.URL.Path, req.URL.RawQuery, strings.TrimSpace(string(body))))
+		switch {
+		case status != http.StatusOK:
+			rw.WriteHeader(status)
+			_, _ = rw.Write([]byte("denied"))
+		case req.URL.Path == "/api/alerts":
+			writeJSON(rw, http.StatusOK, map[string]interface{}{"alerts": []interface{}{}})
+		case strings.HasSuffix(req.URL.Path, "/notes"):
+			writeJSON(rw, http.StatusCreated, map[string]interface{}{"id": uuid.Nil.String(), "alertId": uuid.Nil.String(), "content": "note"})
+		default:
+			t.Errorf("unexpected request %s", req.URL.Path)
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	})
+	return d, &requests
+}
+
+func TestAlertHandlers(t *testing.T) {
+	alert := uuid.NewSHA1(uuid.Nil, []byte("alert")).String()
+	tests := []struct {
+		name    string
+		note    bool
+		method  string
+		body    string
+		aria    int
+		status  int
+		request string
+	}{
+		{name: "take ownership", body: fmt.Sprintf(`{"action":"takeownership","alertIds":[%q]}`, alert), status: http.StatusOK, request: fmt.Sprintf(`/api/alerts action=takeownership {"uuids":[%q]}`, alert)},
+		{name: "suspend", body: fmt.Sprintf(`{"action":"suspend","alertIds":[%q],"minutes":30}`, alert), status: http.StatusOK, request: fmt.Sprintf(`/api/alerts action=suspend&minutes=30 {"uuids":[%q]}`, alert)},
+		{name: "suspend without minutes", body: fmt.Sprintf(`{"action":"suspend","alertIds":[%q]}`, alert), status: http.StatusBadRequest},
+		{name: "unsupported action", body: fmt.Sprintf(`{"action":"delete","alertIds":[%q]}`, alert), status: http.StatusBadRequest},
+		{name: "without alerts", body: `{"action":"cancel"}`, status: http.StatusBadRequest},
+		{name: "invalid action body", body: `{"action":`, status: http.StatusBadRequest},
+		{name: "wrong method", method: http.MethodGet, status: http.StatusMethodNotAllowed},
+		{name: "action unauthorized by Aria", body: fmt.Sprintf(`{"action":"cancel","alertIds":[%q]}`, alert), aria: http.StatusUnauthorized, status: http.StatusUnauthorized, request: fmt.Sprintf(`/api/alerts action=cancel {"uuids":[%q]}`, alert)},
+		{name: "action forbidden by Aria", body: fmt.Sprintf(`{"action":"cancel","alertIds":[%q]}`, alert), aria: http.StatusForbidden, status: http.StatusForbidden, request: fmt.Sprintf(`/api/alerts action=cancel {"uuids":[%q]}`, alert)},
+		{name: "note", note: true, body: fmt.Sprintf(`{"alertId":%q,"content":"checked"}`, alert), status: http.StatusCreated, request: fmt.Sprintf(`/api/alerts/%s/notes  {"content":"checked"}`, alert)},
+		{name: "note without content", note: true, body: fmt.Sprintf(`{"alertId":%q}`, alert), status: http.StatusBadRequest},
+		{name: "invalid note body", note: true, body: `{"alertId":"not a uuid","content":"checked"}`, status: http.StatusBadRequest},
+		{name: "note forbidden by Aria", note: true, body: fmt.Sprintf(`{"alertId":%q,"content":"checked"}`, alert), aria: http.StatusForbidden, status: http.StatusForbidden, request: fmt.Sprintf(`/api/alerts/%s/notes  {"content":"checked"}`, alert)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aria := tt.aria
+			if aria == 0 {
+				aria = http.StatusOK
+			}
+			d, requests := newAlertsDatasource(t, aria)
+			handler := d.modifyAlerts
+			if tt.note {
+				handler = d.addAlertNote
+			}
+			method := tt.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			rec := callHandler(handler, method, tt.body)
+			if rec.Code != tt.status {
+				t.Errorf("expected status %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+			if tt.aria != 0 && !strings.Contains(rec.Body.String(), "denied") {
+				t.Errorf("expected the answer of Aria to be passed on, got %q", rec.Body.String())
+			}
+			var expected []string
+			if tt.request != "" {
+				expected = []string{tt.request}
+			}
+			if !slices.Equal(*requests, expected) {
+				t.Errorf("expected Aria to receive %v, got %v", expected, *requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	maxRetries = 3
+	// The delay before the first retry, doubled for every further retry
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 10 * time.Second
+	// Aria asking to wait longer than this is treated as a failure instead of blocking the query
+	maxRetryAfter = 30 * time.Second
+	// Requests per second of a datasource when no rate limit is configured
+	defaultRateLimit = 20
+)
+
+var (
+	retryCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "plugins",
+			Name:      "aria_request_retries_total",
+			Help:      "A counter for requests to Aria retried after a failure",
+		},
+		[]string{"method", "reason"},
+	)
+
+	rateLimitedCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "plugins",
+			Name:      "aria_rate_limited_requests_total",
+			Help:      "A counter for requests to Aria delayed by the rate limit of the datasource",
+		},
+	)
+
+	rateLimitWaitHistogram = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "plugins",
+			Name:      "aria_rate_limit_wait_seconds",
+			Help:      "histogram of the time requests to Aria waited for the rate limit of the datasource",
+			Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		},
+	)
+)
+
+// Aria answers these while it is overloaded or restarting, the request was not processed
+var retryableStatus = map[int]bool{
+	http.StatusTooManyRequests:    true,
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
+// tokenBucket limits the request rate, it holds up to burst tokens and is refilled with rate tokens per second.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := math.Max(1, 2*rate)
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// reserve takes a token and returns how long the caller has to wait until it is available.
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel returns a token which was reserved but not used.
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(b.burst, b.tokens+1)
+}
+
+// wait blocks until a token is available or the context is done.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	delay := b.reserve()
+	if delay == 0 {
+		return nil
+	}
+	rateLimitedCounter.Inc()
+	rateLimitWaitHistogram.Observe(delay.Seconds())
+	backend.Logger.Debug("Request to Aria delayed by rate limit", "wait", delay)
+	if err := sleep(ctx, delay); err != nil {
+		b.cancel()
+		return err
+	}
+	return nil
+}
+
+// retryTransport rate limits requests to Aria and retries idempotent requests which failed because Aria
+// was overloaded, waiting with exponential backoff or as long as Aria asks with Retry-After.
+type retryTransport struct {
+	base    http.RoundTripper
+	limiter *tokenBucket
+}
+
+func newRetryTransport(base http.RoundTripper, rateLimit float64) *retryTransport {
+	if rateLimit <= 0 {
+		rateLimit = defaultRateLimit
+	}
+	return &retryTransport{base: base, limiter: newTokenBucket(rateLimit)}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	retryable := idempotent(req)
+	for attempt := 0; ; attempt++ {
+		if err := t.limiter.wait(req.Context()); err != nil {
+			return nil, err
+		}
+		// The body of the request was consumed by the previous attempt, retries send a copy
+		attemptReq := req
+		if attempt > 0 {
+			attemptReq = req.Clone(req.Context())
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				attemptReq.Body = body
+			}
+		}
+		resp, err := t.base.RoundTrip(attemptReq)
+		if !retryable || attempt == maxRetries {
+			return resp, err
+		}
+		var reason string
+		var delay time.Duration
+		switch {
+		case err != nil:
+			if !temporary(err) {
+				return resp, err
+			}
+			reason, delay = "network", backoff(attempt)
+		case retryableStatus[resp.StatusCode]:
+			reason, delay = strconv.Itoa(resp.StatusCode), backoff(attempt)
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+				if retryAfter > maxRetryAfter {
+					backend.Logger.Warn("Aria asked to retry later than allowed", "method", req.Method, "path", req.URL.Path, "retryAfter", retryAfter)
+					return resp, err
+				}
+				delay = retryAfter
+			}
+		default:
+			return resp, err
+		}
+		if deadline, ok := req.Context().Deadline(); ok && time.Until(deadline) < delay {
+			return resp, err
+		}
+		if resp != nil {
+			// The connection can only be reused when the body was read
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		retryCounter.WithLabelValues(req.Method, reason).Inc()
+		backend.Logger.Warn("Retrying request to Aria", "method", req.Method, "path", req.URL.Path, "reason", reason, "attempt", attempt+1, "wait", delay, "error", err)
+		if err := sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// idempotent reports whether a request can be sent again. Reads and queries are, actions like modifying
+// alerts or starting maintenance are never repeated.
+func idempotent(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	case http.MethodPost:
+		return strings.HasSuffix(req.URL.Path, "/query") ||
+			strings.HasSuffix(req.URL.Path, "/api/resources/bulk/relationships") ||
+			strings.HasSuffix(req.URL.Path, "/api/auth/token/acquire")
+	}
+	return false
+}
+
+// temporary reports network errors which are worth retrying, cancelled requests are not.
+func temporary(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial" || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// backoff doubles the delay for every attempt, with jitter so throttled requests do not retry in lockstep
+func backoff(attempt int) time.Duration {
+	delay := retryBaseDelay << attempt
+	if delay > retryMaxDelay || delay <= 0 {
+		delay = retryMaxDelay
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// parseRetryAfter reads a Retry-After header in seconds or as an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(0, time.Until(date)), true
+	}
+	return 0, false
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,212 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		// statuses answered by Aria one after another, the last one is repeated
+		statuses   []int
+		retryAfter string
+		attempts   int32
+		status     int
+	}{
+		{name: "get retried until success", method: http.MethodGet, path: "/api/resources", statuses: []int{503, 502, 200}, attempts: 3, status: 200},
+		{name: "get gives up after max retries", method: http.MethodGet, path: "/api/resources", statuses: []int{429}, attempts: maxRetries + 1, status: 429},
+		{name: "client errors are not retried", method: http.MethodGet, path: "/api/resources", statuses: []int{404}, attempts: 1, status: 404},
+		{name: "query is retried with its body", method: http.MethodPost, path: "/api/resources/query", body: `{"name":["vm"]}`, statuses: []int{504, 200}, attempts: 2, status: 200},
+		{name: "actions are not repeated", method: http.MethodPost, path: "/api/alerts", body: `{}`, statuses: []int{503, 200}, attempts: 1, status: 503},
+		{name: "retry later than allowed", method: http.MethodGet, path: "/api/resources", statuses: []int{503, 200}, retryAfter: "3600", attempts: 1, status: 503},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				attempt := int(attempts.Add(1))
+				body, _ := io.ReadAll(req.Body)
+				if string(body) != tt.body {
+					t.Errorf("attempt %d sent body %q, expected %q", attempt, body, tt.body)
+				}
+				retryAfter := tt.retryAfter
+				if retryAfter == "" {
+					// Keeps the test fast, backoff is tested separately
+					retryAfter = "0"
+				}
+				rw.Header().Set("Retry-After", retryAfter)
+				rw.WriteHeader(tt.statuses[min(attempt, len(tt.statuses))-1])
+			}))
+			t.Cleanup(server.Close)
+
+			var body io.Reader
+			if tt.body != "" {
+				body = bytes.NewReader([]byte(tt.body))
+			}
+			req, err := http.NewRequest(tt.method, server.URL+tt.path, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := newRetryTransport(http.DefaultTransport, 10000).RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, resp.StatusCode)
+			}
+			if attempts.Load() != tt.attempts {
+				t.Errorf("expected %d attempts, got %d", tt.attempts, attempts.Load())
+			}
+		})
+	}
+}
+
+func TestRetryTransportStopsWhenCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Retry-After", "10")
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	resp, err := newRetryTransport(http.DefaultTransport, 10000).RoundTrip(req)
+	if err == nil {
+		resp.Body.Close()
+	}
+	// Waiting longer than the deadline allows is pointless, the response is returned right away
+	if time.Since(start) > time.Second {
+		t.Errorf("expected the retry to be skipped, took %v", time.Since(start))
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	tests := []struct {
+		method     string
+		path       string
+		body       io.Reader
+		idempotent bool
+	}{
+		{method: http.MethodGet, path: "/api/resources", idempotent: true},
+		{method: http.MethodHead, path: "/api/resources", idempotent: true},
+		{method: http.MethodPost, path: "/api/resources/query", body: strings.NewReader("{}"), idempotent: true},
+		{method: http.MethodPost, path: "/api/resources/bulk/relationships", body: strings.NewReader("{}"), idempotent: true},
+		{method: http.MethodPost, path: "/api/auth/token/acquire", body: strings.NewReader("{}"), idempotent: true},
+		{method: http.MethodPost, path: "/api/resources/query", body: io.NopCloser(strings.NewReader("{}"))},
+		{method: http.MethodPost, path: "/api/alerts"},
+		{method: http.MethodPut, path: "/api/resources/maintained"},
+		{method: http.MethodDelete, path: "/api/resources/maintained"},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, "https://aria"+tt.path, tt.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if idempotent(req) != tt.idempotent {
+			t.Errorf("%s %s: expected idempotent %v", tt.method, tt.path, tt.idempotent)
+		}
+	}
+}
+
+func TestTemporary(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		temporary bool
+	}{
+		{name: "dial", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, temporary: true},
+		{name: "timeout", err: &net.DNSError{IsTimeout: true}, temporary: true},
+		{name: "connection closed", err: fmt.Errorf("read: %w", io.ErrUnexpectedEOF), temporary: true},
+		{name: "cancelled", err: fmt.Errorf("request: %w", context.Canceled)},
+		{name: "deadline", err: context.DeadlineExceeded},
+		{name: "other", err: errors.New("tls: bad certificate")},
+	}
+	for _, tt := range tests {
+		if temporary(tt.err) != tt.temporary {
+			t.Errorf("%s: expected temporary %v", tt.name, tt.temporary)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 0; attempt < 10; attempt++ {
+		delay := retryBaseDelay << attempt
+		if delay > retryMaxDelay {
+			delay = retryMaxDelay
+		}
+		for i := 0; i < 20; i++ {
+			if d := backoff(attempt); d < delay/2 || d > delay {
+				t.Fatalf("attempt %d: expected between %v and %v, got %v", attempt, delay/2, delay, d)
+			}
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		delay time.Duration
+		ok    bool
+	}{
+		{value: "", ok: false},
+		{value: "0", delay: 0, ok: true},
+		{value: "120", delay: 2 * time.Minute, ok: true},
+		{value: "-1", ok: false},
+		{value: "soon", ok: false},
+		{value: time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), delay: 0, ok: true},
+	}
+	for _, tt := range tests {
+		delay, ok := parseRetryAfter(tt.value)
+		if ok != tt.ok || delay != tt.delay {
+			t.Errorf("%q: expected %v %v, got %v %v", tt.value, tt.delay, tt.ok, delay, ok)
+		}
+	}
+	// Dates in the future are converted into the time until then
+	delay, ok := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	if !ok || delay <= 50*time.Second || delay > time.Minute {
+		t.Errorf("expected about a minute, got %v %v", delay, ok)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	bucket := newTokenBucket(10)
+	// The burst allows twice the rate without waiting
+	for i := 0; i < 20; i++ {
+		if delay := bucket.reserve(); delay != 0 {
+			t.Fatalf("request %d: expected no delay within the burst, got %v", i, delay)
+		}
+	}
+	delay := bucket.reserve()
+	if delay <= 0 || delay > 100*time.Millisecond {
+		t.Errorf("expected to wait for one token, got %v", delay)
+	}
+	bucket.cancel()
+	if delay := bucket.reserve(); delay <= 0 || delay > 100*time.Millisecond {
+		t.Errorf("expected the cancelled token to be available again, got %v", delay)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := bucket.wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected waiting to stop when cancelled, got %v", err)
+	}
+}
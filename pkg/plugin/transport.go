@@ -0,0 +1,221 @@
+package plugin
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"swisscom-vmwareariaoperations-datasource/pkg/models"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
+)
+
+const (
+	// Time to establish a connection to Aria, including the TLS handshake
+	defaultConnectTimeout = 10 * time.Second
+	// Time of a single request to Aria, including retries
+	defaultRequestTimeout = 30 * time.Second
+	// Time of a query, which may consist of several requests
+	defaultQueryTimeout = 60 * time.Second
+)
+
+// timeout converts a timeout setting in seconds, falling back to the default when it is not set.
+func timeout(seconds int, fallback time.Duration) time.Duration {
+	if seconds <= 0 {
+		return fallback
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// loadSettings loads the settings of the datasource together with the secure SOCKS proxy options, which
+// depend on the Grafana configuration passed with the request.
+func loadSettings(ctx context.Context, req *backend.PluginContext) (*models.PluginSettings, error) {
+	config, err := models.LoadPluginSettings(*req.DataSourceInstanceSettings)
+	if err != nil {
+		return nil, err
+	}
+	config.ProxyOptions, err = req.DataSourceInstanceSettings.ProxyOptionsFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secure SOCKS proxy settings: %w", err)
+	}
+	return config, nil
+}
+
+// timeoutOptions translates the timeout settings of the datasource into options of the SDK HTTP client.
+func timeoutOptions(config *models.PluginSettings) *httpclient.TimeoutOptions {
+	timeouts := httpclient.DefaultTimeoutOptions
+	timeouts.DialTimeout = timeout(config.ConnectTimeout, defaultConnectTimeout)
+	timeouts.TLSHandshakeTimeout = timeouts.DialTimeout
+	timeouts.Timeout = timeout(config.RequestTimeout, defaultRequestTimeout)
+	return &timeouts
+}
+
+// httpClientOptions translates the connection settings of the datasource into options of the SDK HTTP client.
+func httpClientOptions(config *models.PluginSettings) httpclient.Options {
+	return httpclient.Options{
+		Timeouts: timeoutOptions(config),
+		TLS: &httpclient.TLSOptions{
+			InsecureSkipVerify: config.TlsSkipVerify,
+			ServerName:         config.TlsServerName,
+			CACertificate:      config.Secrets.TlsCACert,
+			ClientCertificate:  config.Secrets.TlsClientCert,
+			ClientKey:          config.Secrets.TlsClientKey,
+		},
+		ProxyOptions: config.ProxyOptions,
+		ConfigureTransport: func(_ httpclient.Options, transport *http.Transport) {
+			if config.ProxyUrl == "" {
+				return
+			}
+			// validateProxy rejects URLs which do not parse before a client is created
+			if proxyUrl, err := url.Parse(config.ProxyUrl); err == nil {
+				transport.Proxy = http.ProxyURL(proxyUrl)
+			}
+		},
+	}
+}
+
+// newHTTPClient creates the client used for every request to Aria, including token requests. Requests of
+// the client share one rate limit.
+func newHTTPClient(config *models.PluginSettings) (*http.Client, error) {
+	if err := validateProxy(config); err != nil {
+		return nil, err
+	}
+	hc, err := httpclient.New(httpClientOptions(config))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS settings: %w", err)
+	}
+	hc.Transport = newBreakerTransport(newRetryTransport(hc.Transport, config.RateLimit), config, timeout(config.RequestTimeout, defaultRequestTimeout))
+	// The breaker enforces the request timeout
+	hc.Timeout = 0
+	return hc, nil
+}
+
+// newExchangeClient creates the client used for the token exchange, which is shared by all token requests of
+// the datasource. The exchange is reached through the same network as Aria, so the proxy settings and the CA
+// bundle apply, while the server name and the client certificate are those of Aria. Requests bypass the
+// breaker and rate limit of Aria.
+func newExchangeClient(config *models.PluginSettings) (*http.Client, error) {
+	if err := validateProxy(config); err != nil {
+		return nil, err
+	}
+	options := httpClientOptions(config)
+	options.TLS = &httpclient.TLSOptions{CACertificate: config.Secrets.TlsCACert}
+	hc, err := httpclient.New(options)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create token exchange client: %w", err)
+	}
+	return hc, nil
+}
+
+// validateProxy reports proxy settings which can not be used to connect.
+func validateProxy(config *models.PluginSettings) error {
+	if config.ProxyUrl == "" {
+		return nil
+	}
+	if config.ProxyOptions != nil && config.ProxyOptions.Enabled {
+		return fmt.Errorf("use either the proxy URL or the secure SOCKS proxy")
+	}
+	proxyUrl, err := url.Parse(config.ProxyUrl)
+	if err != nil {
+		return fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	switch proxyUrl.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("unsupported scheme of proxy URL %q, use http, https or socks5", proxyUrl.Scheme)
+	}
+	if proxyUrl.Host == "" {
+		return fmt.Errorf("proxy URL %q has no host", config.ProxyUrl)
+	}
+	return nil
+}
+
+// validateTLS reports TLS settings which can not be used to connect.
+func validateTLS(config *models.PluginSettings) error {
+	if config.Secrets.TlsCACert != "" && !x509.NewCertPool().AppendCertsFromPEM([]byte(config.Secrets.TlsCACert)) {
+		return fmt.Errorf("the CA certificate does not contain a PEM encoded certificate")
+	}
+	if (config.Secrets.TlsClientCert == "") != (config.Secrets.TlsClientKey == "") {
+		return fmt.Errorf("the client certificate and key must be configured together")
+	}
+	if config.Secrets.TlsClientCert != "" {
+		if _, err := tls.X509KeyPair([]byte(config.Secrets.TlsClientCert), []byte(config.Secrets.TlsClientKey)); err != nil {
+			return fmt.Errorf("invalid client certificate: %w", err)
+		}
+		if certificate, err := clientCertificate(config); err == nil && time.Now().After(certificate.NotAfter) {
+			return fmt.Errorf("the client certificate expired on %s", certificate.NotAfter.Format(time.DateOnly))
+		}
+	}
+	_, err := httpclient.GetTLSConfig(httpClientOptions(config))
+	return err
+}
+
+// clientCertificate parses the leaf of the configured client certificate chain
+func clientCertificate(config *models.PluginSettings) (*x509.Certificate, error) {
+	pair, err := tls.X509KeyPair([]byte(config.Secrets.TlsClientCert), []byte(config.Secrets.TlsClientKey))
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(pair.Certificate[0])
+}
+
+// certificateExpiry describes when the certificates used for the connection to Aria expire, the server
+// certificate is taken from the TLS handshake of a response.
+func certificateExpiry(config *models.PluginSettings, state *tls.ConnectionState) string {
+	var expiries []string
+	if state != nil && len(state.PeerCertificates) > 0 {
+		expiries = append(expiries, fmt.Sprintf("server certificate expires on %s", state.PeerCertificates[0].NotAfter.Format(time.DateOnly)))
+	}
+	if config.Secrets.TlsClientCert != "" {
+		if certificate, err := clientCertificate(config); err == nil {
+			expiries = append(expiries, fmt.Sprintf("client certificate expires on %s", certificate.NotAfter.Format(time.DateOnly)))
+		}
+	}
+	return strings.Join(expiries, ", ")
+}
+
+// healthErrorMessage explains errors which mean Aria can not be reached with the current settings
+func healthErrorMessage(err error) (string, bool) {
+	// Certificate errors are explained even when they opened the circuit
+	if message, ok := tlsErrorMessage(err); ok {
+		return message, true
+	}
+	var unavailable *unavailableError
+	if errors.As(err, &unavailable) {
+		return unavailable.Error(), true
+	}
+	if errors.Is(err, errMissingIdentity) {
+		return "Sign in to Grafana with OAuth to test forwarding your identity to Aria", true
+	}
+	return "", false
+}
+
+// tlsErrorMessage explains certificate errors, which are the usual reason connections to Aria fail
+func tlsErrorMessage(err error) (string, bool) {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var verification *tls.CertificateVerificationError
+	var invalid x509.CertificateInvalidError
+	var remote *net.OpError
+	switch {
+	case errors.As(err, &unknownAuthority):
+		return "Certificate of Aria is signed by an unknown authority, add its CA certificate or skip TLS verification", true
+	case errors.As(err, &hostname):
+		return fmt.Sprintf("Certificate of Aria is not valid for %s, check the TLS server name", hostname.Host), true
+	case errors.As(err, &invalid):
+		return fmt.Sprintf("Certificate of Aria is invalid: %s", invalid.Error()), true
+	case errors.As(err, &verification):
+		return fmt.Sprintf("Unable to verify the certificate of Aria: %s", verification.Err), true
+	case errors.As(err, &remote) && remote.Op == "remote error" && strings.Contains(remote.Err.Error(), "certificate"):
+		// Aria or a proxy in front of it refused the client certificate
+		return fmt.Sprintf("Client certificate was rejected (%s), check the client certificate and key", remote.Err), true
+	}
+	return "", false
+}
@@ -1,7 +1,10 @@
 package plugin
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"regexp"
 	"strings"
 
@@ -70,3 +73,76 @@ func filter(cf []CustomFilters, resourceName string, tags []Tags) bool {
 	backend.Logger.Debug("Final filtering decision", "resourceName", resourceName, "byTag", byTag, "byName", byName, "skipName", skipName, "skipTag", skipTag, "decision", (byName || skipName) && (byTag || skipTag))
 	return (byName || skipName) && (byTag || skipTag)
 }
+
+// filterText applies custom filters to free text fields, the filter type selects the field by name.
+// Rules referencing a field which is not present are ignored.
+func filterText(cf []CustomFilters, fields map[string]string) bool {
+	for _, rule := range cf {
+		if rule.Operand == "" || rule.Type == "" || rule.Value == "" {
+			backend.Logger.Warn("Wrong filtering rule, one of the fields is empty", "operand", rule.Operand, "type", rule.Type, "value", rule.Value)
+			continue
+		}
+		if rule.Operand == "=~" || rule.Operand == "!~" {
+			_, err := regexp.Compile(rule.Value)
+			if err != nil {
+				backend.Logger.Warn("Wrong filtering rule, wrong regexp", "error", err.Error(), "operand", rule.Operand, "type", rule.Type, "value", rule.Value)
+				continue
+			}
+		}
+		value, ok := fields[rule.Type]
+		if !ok {
+			continue
+		}
+		if !testString(rule.Operand, value, rule.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeAriaResponse reads a raw Aria response into dest. It is used instead of the generated
+// response parsers where the generated models do not match what Aria actually returns.
+func decodeAriaResponse(resp *http.Response, dest any) error {
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	}
+	return json.Unmarshal(body, dest)
+}
+
+// writeJSON encodes v as the body of a resource response with the given status.
+func writeJSON(rw http.ResponseWriter, status int, v any) {
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		backend.Logger.Error("Unable to encode response", "error", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	_, err = rw.Write(jsonData)
+	if err != nil {
+		backend.Logger.Error("Unable to write response", "error", err)
+	}
+}
+
+// writeAriaError passes a non-successful Aria response back to the caller. The original
+// status code is kept so that permission errors (401/403) are distinguishable in Grafana.
+func writeAriaError(rw http.ResponseWriter, operation string, status int, body []byte) {
+	backend.Logger.Error("Aria rejected request", "operation", operation, "StatusCode", status, "error", string(body))
+	var message string
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		message = fmt.Sprintf("Aria denied permission to %s: %s", operation, string(body))
+	default:
+		message = fmt.Sprintf("Aria failed to %s: %s", operation, string(body))
+	}
+	if status == 0 {
+		status = http.StatusBadGateway
+	}
+	http.Error(rw, message, status)
+}
@@ -23,7 +23,12 @@ export const CustomEditor = (props: CustomEditorProps) => {
     onChange({
       ...customQuery,
       pluginVersion,
-      builderOptions: getQueryOptionsFromCustom(query.rawQuery, query.queryType, datasource),
+      // The options are read from the changed query, the backend only runs the builder options
+      builderOptions: getQueryOptionsFromCustom(
+        changes.rawQuery ?? query.rawQuery,
+        changes.queryType || query.queryType,
+        datasource
+      ),
       editorType: EditorType.Custom,
       format: mapQueryTypeToGrafanaFormat(changes.queryType || queryType),
       ...changes,
@@ -0,0 +1,70 @@
+import { Combobox, ComboboxOption, InlineField, Input, Stack } from '@grafana/ui';
+import React from 'react';
+import { Aggregation, AggregationOperator, QueryBuilderOptions } from '../../types/queryBuilder';
+import { DataSource } from '../../datasource';
+import { MultiSelectMetricPropertyTag } from './Select';
+import useFetchMetricsPropertiesTags from '../../hooks/useFetchMetricsPropertiesTags';
+import labels from '../../labels';
+
+type AggregationFormProps = {
+  datasource: DataSource;
+  builderOptions: QueryBuilderOptions;
+  changeFunction: (aggregation: Aggregation) => void;
+};
+
+const operators: Array<ComboboxOption<string>> = (Object.values(AggregationOperator) as string[]).map((v) => ({
+  label: v,
+  value: v,
+}));
+
+// Labels every series has, properties of the resources can be used as well
+const builtinLabels = ['adapterKind', 'resourceKind', 'resourceId', 'resourceName', 'offset'];
+
+/**
+ * Edits the aggregation combining the series of a query per group of label values.
+ */
+export const AggregationForm = (props: AggregationFormProps) => {
+  const { datasource, builderOptions, changeFunction } = props;
+  const [, properties] = useFetchMetricsPropertiesTags(datasource, builderOptions);
+  const aggregation = builderOptions.aggregation || { operator: '', groupBy: [] };
+  const { label, tooltip } = labels.components.transformations.AggregationSelect;
+
+  return (
+    <Stack direction="row" wrap="wrap" alignItems="start" justifyContent="start" gap={0}>
+      <InlineField labelWidth={17} label={label} tooltip={tooltip}>
+        <Combobox
+          options={operators}
+          value={aggregation.operator}
+          onChange={(e) => changeFunction({ ...aggregation, operator: e?.value ?? '', percentile: undefined })}
+          width={25}
+          isClearable={true}
+          createCustomValue={false}
+        />
+      </InlineField>
+      {aggregation.operator === AggregationOperator.Percentile && (
+        <Input
+          type="number"
+          value={aggregation.percentile ?? ''}
+          placeholder="percentile, e.g. 95"
+          min={0}
+          max={100}
+          onChange={(e) =>
+            changeFunction({
+              ...aggregation,
+              percentile: e.currentTarget.value === '' ? undefined : Number(e.currentTarget.value),
+            })
+          }
+          width={20}
+        />
+      )}
+      {aggregation.operator && (
+        <MultiSelectMetricPropertyTag
+          labels={labels.components.transformations.GroupBySelect}
+          fetchedOptions={[...builtinLabels, ...properties]}
+          changeFunction={(groupBy) => changeFunction({ ...aggregation, groupBy })}
+          values={aggregation.groupBy || []}
+        />
+      )}
+    </Stack>
+  );
+};
@@ -7,6 +7,7 @@ type CustomFilterProps = {
   filterKey: number;
   builderOptions: QueryBuilderOptions;
   filter: CustomFilter;
+  types?: string[];
 };
 
 export const CustomFilterBlock = (props: CustomFilterProps) => {
@@ -15,7 +16,7 @@ export const CustomFilterBlock = (props: CustomFilterProps) => {
   const types:
     | ComboboxOption[]
     | ((inputValue: string) => Promise<ComboboxOption[]>)
-    | Array<{ label: any; value: any }> = (Object.values(CustomFilterType) as CustomFilterType[]).map((v) => {
+    | Array<{ label: any; value: any }> = (props.types || (Object.values(CustomFilterType) as string[])).map((v) => {
     return { key: v, value: v };
   });
   const operands:
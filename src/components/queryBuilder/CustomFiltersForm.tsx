@@ -7,10 +7,13 @@ import { CustomFilter, QueryBuilderOptions } from '../../types/queryBuilder';
 type CustomFilterFormProps = {
   changeFunction: (customFilters: CustomFilter[]) => void;
   builderOptions: QueryBuilderOptions;
+  // Filter types offered instead of the resource filters
+  types?: string[];
+  tooltip?: string;
 };
 
 export const CustomFilterForm = (props: CustomFilterFormProps) => {
-  const { changeFunction, builderOptions } = props;
+  const { changeFunction, builderOptions, types, tooltip } = props;
   return (
     <>
       <div className={'gf-form ' + styles.QueryEditor.queryType}>
@@ -19,7 +22,10 @@ export const CustomFilterForm = (props: CustomFilterFormProps) => {
           size="sm"
           variant="secondary"
           aria-label="Add"
-          tooltip="The data can be filtered by resourceName or tags (the tags have to be specified manually following the format tag|TagName for example tag|AlarmingLevel). The result will be Union (AND) of all conditions."
+          tooltip={
+            tooltip ||
+            'The data can be filtered by resourceName or tags (the tags have to be specified manually following the format tag|TagName for example tag|AlarmingLevel). The result will be Union (AND) of all conditions.'
+          }
           onClick={() => {
             changeFunction([...builderOptions.customFilters, { type: null, operand: null, value: null }]);
           }}
@@ -36,6 +42,7 @@ export const CustomFilterForm = (props: CustomFilterFormProps) => {
               filterKey={key}
               filter={filter}
               builderOptions={builderOptions}
+              types={types}
             />
           ))}
         </Grid>
@@ -0,0 +1,95 @@
+import { Combobox, ComboboxOption, InlineField, Input, Stack } from '@grafana/ui';
+import React from 'react';
+import { Forecast, ForecastModel, ForecastOutput } from '../../types/queryBuilder';
+import labels from '../../labels';
+
+type ForecastFormProps = {
+  forecast: Forecast;
+  changeFunction: (forecast: Forecast) => void;
+};
+
+const models: Array<ComboboxOption<string>> = (Object.values(ForecastModel) as string[]).map((v) => ({
+  label: v,
+  value: v,
+}));
+
+const outputs: Array<ComboboxOption<string>> = (Object.values(ForecastOutput) as string[]).map((v) => ({
+  label: v,
+  value: v,
+}));
+
+const parseNumber = (value: string): number | undefined => (value === '' ? undefined : Number(value));
+
+/**
+ * Edits the forecast appended to the series of a query.
+ */
+export const ForecastForm = (props: ForecastFormProps) => {
+  const { changeFunction } = props;
+  const forecast = props.forecast || { model: '', horizon: '' };
+  const { label, tooltip } = labels.components.transformations.ForecastSelect;
+  const output = labels.components.transformations.ForecastOutputSelect;
+
+  return (
+    <Stack direction="row" wrap="wrap" alignItems="start" justifyContent="start" gap={0}>
+      <InlineField labelWidth={17} label={label} tooltip={tooltip}>
+        <Combobox
+          options={models}
+          value={forecast.model}
+          onChange={(e) =>
+            // Clearing the model removes the forecast
+            changeFunction(e?.value ? { ...forecast, model: e.value } : { model: '', horizon: '' })
+          }
+          width={25}
+          isClearable={true}
+          createCustomValue={false}
+        />
+      </InlineField>
+      {forecast.model && (
+        <>
+          <Input
+            value={forecast.horizon}
+            placeholder="horizon, e.g. 30d"
+            onChange={(e) => changeFunction({ ...forecast, horizon: e.currentTarget.value })}
+            width={20}
+          />
+          {forecast.model === ForecastModel.HoltWinters && (
+            <Input
+              value={forecast.season || ''}
+              placeholder="season, 1d by default"
+              onChange={(e) => changeFunction({ ...forecast, season: e.currentTarget.value || undefined })}
+              width={22}
+            />
+          )}
+          <Input
+            type="number"
+            value={forecast.confidence ?? ''}
+            placeholder="confidence, 0.95 by default"
+            min={0}
+            max={1}
+            step={0.01}
+            onChange={(e) => changeFunction({ ...forecast, confidence: parseNumber(e.currentTarget.value) })}
+            width={28}
+          />
+          <InlineField labelWidth={10} label={output.label} tooltip={output.tooltip}>
+            <Combobox
+              options={outputs}
+              value={forecast.output || ForecastOutput.Series}
+              onChange={(e) => changeFunction({ ...forecast, output: e?.value, threshold: undefined })}
+              width={25}
+              createCustomValue={false}
+            />
+          </InlineField>
+          {forecast.output === ForecastOutput.TimeUntilThreshold && (
+            <Input
+              type="number"
+              value={forecast.threshold ?? ''}
+              placeholder="threshold"
+              onChange={(e) => changeFunction({ ...forecast, threshold: parseNumber(e.currentTarget.value) })}
+              width={15}
+            />
+          )}
+        </>
+      )}
+    </Stack>
+  );
+};
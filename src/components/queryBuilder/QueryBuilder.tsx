@@ -1,9 +1,11 @@
 import React from 'react';
 import { CoreApp } from '@grafana/data';
-import { CustomFilter, QueryBuilderOptions, QueryType } from '../../types/queryBuilder';
+import { InlineField, InlineSwitch, Input, Stack } from '@grafana/ui';
+import { CustomFilter, QueryBuilderOptions, QueryType, resourceQueryTypes } from '../../types/queryBuilder';
 import {
   BuilderOptionsReducerAction,
   setAdapterKind,
+  setExcludeMaintained,
   setQueryType,
   setResourceKind,
   setWhereHealth,
@@ -12,6 +14,7 @@ import {
   setWithFilters,
   setWithMetric,
   setWithProperty,
+  setWithSuperMetric,
 } from '../../hooks/useBuilderOptionsState';
 import { DataSource } from '../../datasource';
 import { SelectForm } from './Select';
@@ -19,6 +22,10 @@ import { styles } from 'styles';
 import { QueryTypeSwitcher } from './QueryTypeSwitcher';
 import { TableQueryBuilder } from '../../views/TableQueryBuilder';
 import { TimeSeriesQueryBuilder } from '../../views/TimeSeriesQueryBuilder';
+import { FormulaQueryBuilder } from '../../views/FormulaQueryBuilder';
+import { PlatformHealthQueryBuilder } from '../../views/PlatformHealthQueryBuilder';
+import { AdapterInstancesQueryBuilder } from '../../views/AdapterInstancesQueryBuilder';
+import { MaintenanceQueryBuilder } from '../../views/MaintenanceQueryBuilder';
 import { KeyValue } from '../../types';
 import { MultiChangeFunction, UseFetch } from '../utils';
 import labels, { Labels } from '../../labels';
@@ -49,6 +56,12 @@ export const QueryBuilder = (props: QueryBuilderProps) => {
   const onWithMetricChange = (withMetric: string) => builderOptionsDispatch(setWithMetric(withMetric));
   const onWithPropertyChange = (withProperty: string[]) => builderOptionsDispatch(setWithProperty(withProperty));
   const onWithFiltersChange = (customFilters: CustomFilter[]) => builderOptionsDispatch(setWithFilters(customFilters));
+  const onWithSuperMetricChange = (withSuperMetric: string) =>
+    builderOptionsDispatch(setWithSuperMetric(withSuperMetric));
+  const onExcludeMaintainedChange = (excludeMaintained: boolean) =>
+    builderOptionsDispatch(setExcludeMaintained(excludeMaintained));
+  const superMetric = labels.components.collectors.WithSuperMetricInput;
+  const excludeMaintained = labels.components.filters.ExcludeMaintainedSwitch;
   const FiltersMap: KeyValue<[string[], MultiChangeFunction, Labels, UseFetch]> = {
     whereHealth: [
       builderOptions.filters.whereHealth,
@@ -71,23 +84,46 @@ export const QueryBuilder = (props: QueryBuilderProps) => {
   };
   return (
     <div data-testid="query-editor-section-builder">
-      <div className={'gf-form ' + styles.QueryEditor.queryType}>
-        <SelectForm
-          datasource={datasource}
-          filters={FiltersMap}
-          builderOptions={builderOptions}
-          onWithMetricChange={onWithMetricChange}
-          onWithPropertyChange={onWithPropertyChange}
-          onAdapterKindChange={onAdapterKindChange}
-          onResourceKindChange={onResourceKindChange}
-        />
-      </div>
-      <div>
-        <CustomFilterForm changeFunction={onWithFiltersChange} builderOptions={builderOptions}></CustomFilterForm>
-      </div>
       <div className={'gf-form ' + styles.QueryEditor.queryType}>
         <QueryTypeSwitcher queryType={builderOptions.queryType} onChange={onQueryTypeChange} />
       </div>
+      {/* Only the resource queries select resources, the other query types have their own options */}
+      {resourceQueryTypes.includes(builderOptions.queryType) && (
+        <>
+          <div className={'gf-form ' + styles.QueryEditor.queryType}>
+            <SelectForm
+              datasource={datasource}
+              filters={FiltersMap}
+              builderOptions={builderOptions}
+              onWithMetricChange={onWithMetricChange}
+              onWithPropertyChange={onWithPropertyChange}
+              onAdapterKindChange={onAdapterKindChange}
+              onResourceKindChange={onResourceKindChange}
+            />
+          </div>
+          <div className={'gf-form ' + styles.QueryEditor.queryType}>
+            <Stack direction="row" wrap="wrap" alignItems="start" justifyContent="start" gap={0}>
+              <InlineField labelWidth={17} label={superMetric.label} tooltip={superMetric.tooltip}>
+                <Input
+                  value={builderOptions.functions.withSuperMetric || ''}
+                  placeholder={superMetric.empty}
+                  onChange={(e) => onWithSuperMetricChange(e.currentTarget.value)}
+                  width={25}
+                />
+              </InlineField>
+              <InlineField labelWidth={17} label={excludeMaintained.label} tooltip={excludeMaintained.tooltip}>
+                <InlineSwitch
+                  value={builderOptions.filters.excludeMaintained || false}
+                  onChange={(e) => onExcludeMaintainedChange(e.currentTarget.checked)}
+                />
+              </InlineField>
+            </Stack>
+          </div>
+          <div>
+            <CustomFilterForm changeFunction={onWithFiltersChange} builderOptions={builderOptions}></CustomFilterForm>
+          </div>
+        </>
+      )}
 
       {builderOptions.queryType === QueryType.Table && (
         <TableQueryBuilder
@@ -103,6 +139,34 @@ export const QueryBuilder = (props: QueryBuilderProps) => {
           builderOptionsDispatch={builderOptionsDispatch}
         />
       )}
+      {builderOptions.queryType === QueryType.Formula && (
+        <FormulaQueryBuilder
+          datasource={datasource}
+          builderOptions={builderOptions}
+          builderOptionsDispatch={builderOptionsDispatch}
+        />
+      )}
+      {builderOptions.queryType === QueryType.PlatformHealth && (
+        <PlatformHealthQueryBuilder
+          datasource={datasource}
+          builderOptions={builderOptions}
+          builderOptionsDispatch={builderOptionsDispatch}
+        />
+      )}
+      {builderOptions.queryType === QueryType.AdapterInstances && (
+        <AdapterInstancesQueryBuilder
+          datasource={datasource}
+          builderOptions={builderOptions}
+          builderOptionsDispatch={builderOptionsDispatch}
+        />
+      )}
+      {builderOptions.queryType === QueryType.Maintenance && (
+        <MaintenanceQueryBuilder
+          datasource={datasource}
+          builderOptions={builderOptions}
+          builderOptionsDispatch={builderOptionsDispatch}
+        />
+      )}
     </div>
   );
 };
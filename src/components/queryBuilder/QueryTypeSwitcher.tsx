@@ -18,6 +18,26 @@ const options = [
     label: labels.types.QueryType.timeseries,
     value: QueryType.TimeSeries,
   },
+  {
+    label: labels.types.QueryType.formula,
+    value: QueryType.Formula,
+  },
+  {
+    label: labels.types.QueryType.platformHealth,
+    value: QueryType.PlatformHealth,
+  },
+  {
+    label: labels.types.QueryType.collectors,
+    value: QueryType.Collectors,
+  },
+  {
+    label: labels.types.QueryType.adapterInstances,
+    value: QueryType.AdapterInstances,
+  },
+  {
+    label: labels.types.QueryType.maintenance,
+    value: QueryType.Maintenance,
+  },
 ];
 
 /**
@@ -239,7 +239,7 @@ export const SelectForm = (props: SelectFormProps) => {
         <MultiSelect
           datasource={datasource}
           builderOptions={builderOptions}
-          values={builderOptions.filters[name as keyof Filters] || []}
+          values={builderOptions.filters[name as Exclude<keyof Filters, 'excludeMaintained'>] || []}
           changeFunction={filters[name][1]}
           key={name}
           labels={filters[name][2]}
@@ -0,0 +1,85 @@
+import { Button, Combobox, ComboboxOption, IconButton, InlineField, Input, Stack } from '@grafana/ui';
+import React from 'react';
+import { SeriesFunction, SeriesFunctionType } from '../../types/queryBuilder';
+import labels from '../../labels';
+
+type SeriesFunctionsFormProps = {
+  changeFunction: (seriesFunctions: SeriesFunction[]) => void;
+  seriesFunctions: SeriesFunction[];
+};
+
+const functions: Array<ComboboxOption<string>> = (Object.values(SeriesFunctionType) as string[]).map((v) => ({
+  label: v,
+  value: v,
+}));
+
+/**
+ * Edits the functions applied in order to every metric series.
+ */
+export const SeriesFunctionsForm = (props: SeriesFunctionsFormProps) => {
+  const { changeFunction, seriesFunctions } = props;
+  const { label, tooltip } = labels.components.transformations.SeriesFunctionSelect;
+
+  const update = (key: number, change: Partial<SeriesFunction>) => {
+    const newFunctions = [...seriesFunctions]; // copy the array
+    newFunctions[key] = { ...newFunctions[key], ...change };
+    changeFunction(newFunctions);
+  };
+
+  return (
+    <Stack direction="column" gap={0}>
+      {seriesFunctions.map((f, key) => (
+        <InlineField key={key} labelWidth={17} label={label} tooltip={tooltip}>
+          <Stack direction="row" wrap="nowrap" alignItems="center" justifyContent="start" gap={1}>
+            <Combobox
+              options={functions}
+              value={f.function}
+              onChange={(e) => update(key, { function: e?.value! })}
+              width={25}
+              createCustomValue={false}
+            />
+            <Input
+              value={f.window || ''}
+              placeholder="window, e.g. 30m"
+              onChange={(e) => update(key, { window: e.currentTarget.value || undefined })}
+              width={20}
+            />
+            {f.function === SeriesFunctionType.ExponentialSmoothing && (
+              <Input
+                type="number"
+                value={f.alpha ?? ''}
+                placeholder="alpha"
+                min={0}
+                max={1}
+                step={0.1}
+                onChange={(e) =>
+                  update(key, { alpha: e.currentTarget.value === '' ? undefined : Number(e.currentTarget.value) })
+                }
+                width={10}
+              />
+            )}
+            <IconButton
+              name="times"
+              size="lg"
+              variant="destructive"
+              aria-label="Remove"
+              onClick={() => changeFunction(seriesFunctions.filter((_, i) => i !== key))}
+            />
+          </Stack>
+        </InlineField>
+      ))}
+      <div>
+        <Button
+          icon="plus"
+          size="sm"
+          variant="secondary"
+          aria-label="Add function"
+          tooltip={tooltip}
+          onClick={() => changeFunction([...seriesFunctions, { function: SeriesFunctionType.Rate }])}
+        >
+          Add Function
+        </Button>
+      </div>
+    </Stack>
+  );
+};
@@ -14,17 +14,29 @@ export function getQueryOptionsFromCustom(
       adapterKind: builderOptions.functions['adapterKind'],
       resourceKind: builderOptions.functions['resourceKind'],
       withMetric: builderOptions.functions['withMetric'],
+      withSuperMetric: builderOptions.functions['withSuperMetric'],
+      adapterInstanceId: builderOptions.functions['adapterInstanceId'],
     },
     filters: {
       whereHealth: builderOptions.filters['whereHealth'],
       whereState: builderOptions.filters['whereState'],
       whereStatus: builderOptions.filters['whereStatus'],
       whereTag: builderOptions.filters['whereTag'],
+      whereService: builderOptions.filters['whereService'],
+      excludeMaintained: builderOptions.filters['excludeMaintained'],
     },
     collectors: {
       withProperty: builderOptions.collectors['withProperty'],
     },
     customFilters: builderOptions.customFilters,
+    aggregation: builderOptions.aggregation,
+    formula: builderOptions.formula,
+    expression: builderOptions.expression,
+    seriesFunctions: builderOptions.seriesFunctions,
+    forecast: builderOptions.forecast,
+    timeShift: builderOptions.timeShift,
+    disableDownsampling: builderOptions.disableDownsampling,
+    queryType: queryType,
   } as QueryBuilderOptions;
 }
 
@@ -35,8 +47,13 @@ export function getQueryOptionsFromCustom(
 export const mapQueryTypeToGrafanaFormat = (t?: QueryType): number => {
   switch (t) {
     case QueryType.Table:
+    case QueryType.PlatformHealth:
+    case QueryType.Collectors:
+    case QueryType.AdapterInstances:
+    case QueryType.Maintenance:
       return 1;
     case QueryType.TimeSeries:
+    case QueryType.Formula:
       return 0;
     default:
       return 1 << 8; // an unused u32, defaults to timeseries/graph on plugin backend.
@@ -8,7 +8,7 @@ export const generateQuery = (options: QueryBuilderOptions): string => {
     }
   });
   Object.entries(options.filters).forEach(([key, value]) => {
-    if (value && value.length > 0) {
+    if (value === true || (Array.isArray(value) && value.length > 0)) {
       params.push(`${key}(${value})`);
     }
   });
@@ -22,6 +22,41 @@ export const generateQuery = (options: QueryBuilderOptions): string => {
       params.push(`where(${value.type || ''}${value.operand || ''}${value.value || ''})`);
     }
   });
+  if (options.formula) {
+    params.push(`formula(${options.formula})`);
+  }
+  (options.seriesFunctions || []).forEach((f) => {
+    if (f.function) {
+      // Missing window and alpha are left empty, trailing ones are omitted
+      params.push(`series(${[f.function, f.window || '', f.alpha ?? ''].join(',').replace(/,+$/, '')})`);
+    }
+  });
+  if (options.timeShift?.length) {
+    params.push(`timeShift(${options.timeShift})`);
+  }
+  if (options.expression) {
+    params.push(`expression(${options.expression})`);
+  }
+  if (options.aggregation?.operator) {
+    const { operator, percentile } = options.aggregation;
+    params.push(`aggregate(${operator}${percentile !== undefined ? `,${percentile}` : ''})`);
+  }
+  if (options.aggregation?.groupBy?.length) {
+    params.push(`groupBy(${options.aggregation.groupBy})`);
+  }
+  if (options.forecast?.model) {
+    const { model, horizon, ...settings } = options.forecast;
+    const forecast = [model, horizon];
+    Object.entries(settings).forEach(([key, value]) => {
+      if (value !== undefined && value !== '') {
+        forecast.push(`${key}=${value}`);
+      }
+    });
+    params.push(`forecast(${forecast.join(',')})`);
+  }
+  if (options.disableDownsampling) {
+    params.push('disableDownsampling(true)');
+  }
 
   return params.join('.');
 };
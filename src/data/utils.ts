@@ -1,6 +1,10 @@
-import { QueryBuilderOptions, QueryType } from '../types/queryBuilder';
+import { QueryBuilderOptions, QueryType, resourceQueryTypes } from '../types/queryBuilder';
 
 export const isBuilderOptionsRunnable = (builderOptions: QueryBuilderOptions): boolean => {
+  // Queries of the Aria platform do not select resources
+  if (builderOptions.queryType && !resourceQueryTypes.includes(builderOptions.queryType)) {
+    return true;
+  }
   return (builderOptions.functions.adapterKind?.length || 0) > 0;
 };
 
@@ -11,8 +15,13 @@ export const isBuilderOptionsRunnable = (builderOptions: QueryBuilderOptions): b
 export const mapQueryBuilderOptionsToGrafanaFormat = (t?: QueryBuilderOptions): number => {
   switch (t?.queryType) {
     case QueryType.Table:
+    case QueryType.PlatformHealth:
+    case QueryType.Collectors:
+    case QueryType.AdapterInstances:
+    case QueryType.Maintenance:
       return 1;
     case QueryType.TimeSeries:
+    case QueryType.Formula:
       return 0;
     default:
       return 1 << 8; // an unused u32, defaults to timeseries/graph on plugin backend.
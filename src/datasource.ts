@@ -2,11 +2,22 @@ import { DataSourceInstanceSettings, CoreApp, ScopedVars } from '@grafana/data';
 import { BackendSrvRequest, DataSourceWithBackend, getTemplateSrv } from '@grafana/runtime';
 
 import { AriaSourceOptions, MetricPropertyTagResponse } from './types';
-import { AriaQuery, defaultBuilderQuery, QueryBuilderOptionsBase } from './types/queryBuilder';
+import {
+  AriaQuery,
+  defaultAnnotationQuery,
+  defaultBuilderQuery,
+  QueryBuilderOptionsBase,
+  resourceQueryTypes,
+} from './types/queryBuilder';
 
 export class DataSource extends DataSourceWithBackend<AriaQuery, AriaSourceOptions> {
   constructor(instanceSettings: DataSourceInstanceSettings<AriaSourceOptions>) {
     super(instanceSettings);
+    // Enables annotation queries handled by the backend, edited with the query editor and showing maintenance
+    // windows by default
+    this.annotations = {
+      getDefaultQuery: () => defaultAnnotationQuery,
+    };
   }
 
   getDefaultQuery(_: CoreApp): Partial<AriaQuery> {
@@ -21,8 +32,10 @@ export class DataSource extends DataSourceWithBackend<AriaQuery, AriaSourceOptio
   }
 
   filterQuery(query: AriaQuery): boolean {
-    // if no query has been provided, prevent the query from being executed
-    return !!query.rawQuery;
+    // if no query has been provided, prevent the query from being executed. Queries of the Aria platform do
+    // not need one.
+    const queryType = query.builderOptions?.queryType;
+    return !!query.rawQuery || (!!queryType && !resourceQueryTypes.includes(queryType));
   }
 
   fetchAdapterResourceKinds(): Promise<Record<string, string[]>> {
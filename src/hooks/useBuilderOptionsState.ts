@@ -1,11 +1,14 @@
 import {
+  Aggregation,
   Collectors,
   CustomFilter,
   defaultBuilderQuery,
   Filters,
+  Forecast,
   Functions,
   QueryBuilderOptions,
   QueryType,
+  SeriesFunction,
 } from '../types/queryBuilder';
 
 import { Reducer, useReducer } from 'react';
@@ -23,10 +26,22 @@ enum BuilderOptionsActionType {
   SetWhereState = 'where_state',
   SetWhereStatus = 'where_status',
   SetWhereTag = 'where_tag',
+  SetWhereService = 'where_service',
+  SetExcludeMaintained = 'exclude_maintained',
 
   SetWithMetric = 'with_metric',
+  SetWithSuperMetric = 'with_super_metric',
   SetWithProperty = 'with_property',
   SetWithFilter = 'with_filter',
+  SetAdapterInstanceId = 'adapter_instance_id',
+
+  SetFormula = 'formula',
+  SetExpression = 'expression',
+  SetSeriesFunctions = 'series_functions',
+  SetTimeShift = 'time_shift',
+  SetAggregation = 'aggregation',
+  SetForecast = 'forecast',
+  SetDisableDownsampling = 'disable_downsampling',
 }
 
 type QueryBuilderOptionsReducerAction = {
@@ -69,6 +84,28 @@ export const setWithProperty = (withProperty: string[]): BuilderOptionsReducerAc
   createAction(BuilderOptionsActionType.SetWithProperty, { withProperty });
 export const setWithFilters = (customFilters: CustomFilter[]): BuilderOptionsReducerAction =>
   createAction(BuilderOptionsActionType.SetWithFilter, { customFilters });
+export const setWhereService = (whereService: string[]): BuilderOptionsReducerAction =>
+  createAction(BuilderOptionsActionType.SetWhereService, { whereService });
+export const setExcludeMaintained = (excludeMaintained: boolean): BuilderOptionsReducerAction =>
+  createAction(BuilderOptionsActionType.SetExcludeMaintained, { excludeMaintained });
+export const setWithSuperMetric = (withSuperMetric: string): BuilderOptionsReducerAction =>
+  createAction(BuilderOptionsActionType.SetWithSuperMetric, { withSuperMetric });
+export const setAdapterInstanceId = (adapterInstanceId: string): BuilderOptionsReducerAction =>
+  createAction(BuilderOptionsActionType.SetAdapterInstanceId, { adapterInstanceId });
+export const setFormula = (formula: string): BuilderOptionsReducerAction =>
+  createAction(BuilderOptionsActionType.SetFormula, { formula });
+export const setExpression = (expression: string): BuilderOptionsReducerAction =>
+  createAction(BuilderOptionsActionType.SetExpression, { expression });
+export const setSeriesFunctions = (seriesFunctions: SeriesFunction[]): BuilderOptionsReducerAction =>
+  createAction(BuilderOptionsActionType.SetSeriesFunctions, { seriesFunctions });
+export const setTimeShift = (timeShift: string[]): BuilderOptionsReducerAction =>
+  createAction(BuilderOptionsActionType.SetTimeShift, { timeShift });
+export const setAggregation = (aggregation: Aggregation): BuilderOptionsReducerAction =>
+  createAction(BuilderOptionsActionType.SetAggregation, { aggregation });
+export const setForecast = (forecast: Forecast): BuilderOptionsReducerAction =>
+  createAction(BuilderOptionsActionType.SetForecast, { forecast });
+export const setDisableDownsampling = (disableDownsampling: boolean): BuilderOptionsReducerAction =>
+  createAction(BuilderOptionsActionType.SetDisableDownsampling, { disableDownsampling });
 
 const reducer = (state: QueryBuilderOptions, action: BuilderOptionsReducerAction): QueryBuilderOptions => {
   const actionFn = actions.get(action.type);
@@ -148,10 +185,8 @@ const actions = new Map<BuilderOptionsActionType, Reducer<QueryBuilderOptions, B
         adapterKind: action.payload.adapterKind,
       };
       return buildInitialState({
+        ...state,
         functions: functions,
-        collectors: state.collectors,
-        filters: state.filters,
-        customFilters: state.customFilters,
       });
     },
   ],
@@ -163,10 +198,8 @@ const actions = new Map<BuilderOptionsActionType, Reducer<QueryBuilderOptions, B
         resourceKind: action.payload.resourceKind,
       };
       return buildInitialState({
+        ...state,
         functions: functions,
-        collectors: state.collectors,
-        filters: state.filters,
-        customFilters: state.customFilters,
       });
     },
   ],
@@ -179,10 +212,8 @@ const actions = new Map<BuilderOptionsActionType, Reducer<QueryBuilderOptions, B
         whereHealth: action.payload.whereHealth,
       };
       return buildInitialState({
+        ...state,
         filters: filters,
-        functions: state.functions,
-        collectors: state.collectors,
-        customFilters: state.customFilters,
       });
     },
   ],
@@ -195,10 +226,8 @@ const actions = new Map<BuilderOptionsActionType, Reducer<QueryBuilderOptions, B
         whereState: action.payload.whereState,
       };
       return buildInitialState({
+        ...state,
         filters: filters,
-        functions: state.functions,
-        collectors: state.collectors,
-        customFilters: state.customFilters,
       });
     },
   ],
@@ -211,10 +240,8 @@ const actions = new Map<BuilderOptionsActionType, Reducer<QueryBuilderOptions, B
         whereStatus: action.payload.whereStatus,
       };
       return buildInitialState({
+        ...state,
         filters: filters,
-        functions: state.functions,
-        collectors: state.collectors,
-        customFilters: state.customFilters,
       });
     },
   ],
@@ -227,10 +254,8 @@ const actions = new Map<BuilderOptionsActionType, Reducer<QueryBuilderOptions, B
         whereTag: action.payload.whereTag,
       };
       return buildInitialState({
+        ...state,
         filters: filters,
-        functions: state.functions,
-        collectors: state.collectors,
-        customFilters: state.customFilters,
       });
     },
   ],
@@ -243,10 +268,8 @@ const actions = new Map<BuilderOptionsActionType, Reducer<QueryBuilderOptions, B
         withProperty: action.payload.withProperty,
       };
       return buildInitialState({
+        ...state,
         collectors: collectors,
-        functions: state.functions,
-        filters: state.filters,
-        customFilters: state.customFilters,
       });
     },
   ],
@@ -258,10 +281,8 @@ const actions = new Map<BuilderOptionsActionType, Reducer<QueryBuilderOptions, B
         withMetric: action.payload.withMetric,
       };
       return buildInitialState({
-        collectors: state.collectors,
+        ...state,
         functions: functions,
-        filters: state.filters,
-        customFilters: state.customFilters,
       });
     },
   ],
@@ -269,11 +290,124 @@ const actions = new Map<BuilderOptionsActionType, Reducer<QueryBuilderOptions, B
     BuilderOptionsActionType.SetWithFilter,
     (state: QueryBuilderOptions, action: BuilderOptionsReducerAction): QueryBuilderOptions => {
       return buildInitialState({
-        collectors: state.collectors,
-        functions: state.functions,
-        filters: state.filters,
+        ...state,
         customFilters: action.payload.customFilters,
       });
     },
   ],
+  [
+    BuilderOptionsActionType.SetWhereService,
+    (state: QueryBuilderOptions, action: BuilderOptionsReducerAction): QueryBuilderOptions => {
+      const filters: Filters = {
+        ...state.filters,
+        whereService: action.payload.whereService,
+      };
+      return buildInitialState({
+        ...state,
+        filters: filters,
+      });
+    },
+  ],
+  [
+    BuilderOptionsActionType.SetExcludeMaintained,
+    (state: QueryBuilderOptions, action: BuilderOptionsReducerAction): QueryBuilderOptions => {
+      const filters: Filters = {
+        ...state.filters,
+        excludeMaintained: action.payload.excludeMaintained,
+      };
+      return buildInitialState({
+        ...state,
+        filters: filters,
+      });
+    },
+  ],
+  [
+    BuilderOptionsActionType.SetWithSuperMetric,
+    (state: QueryBuilderOptions, action: BuilderOptionsReducerAction): QueryBuilderOptions => {
+      const functions: Functions = {
+        ...state.functions,
+        withSuperMetric: action.payload.withSuperMetric,
+      };
+      return buildInitialState({
+        ...state,
+        functions: functions,
+      });
+    },
+  ],
+  [
+    BuilderOptionsActionType.SetAdapterInstanceId,
+    (state: QueryBuilderOptions, action: BuilderOptionsReducerAction): QueryBuilderOptions => {
+      const functions: Functions = {
+        ...state.functions,
+        adapterInstanceId: action.payload.adapterInstanceId,
+      };
+      return buildInitialState({
+        ...state,
+        functions: functions,
+      });
+    },
+  ],
+  [
+    BuilderOptionsActionType.SetFormula,
+    (state: QueryBuilderOptions, action: BuilderOptionsReducerAction): QueryBuilderOptions => {
+      return buildInitialState({
+        ...state,
+        formula: action.payload.formula,
+      });
+    },
+  ],
+  [
+    BuilderOptionsActionType.SetExpression,
+    (state: QueryBuilderOptions, action: BuilderOptionsReducerAction): QueryBuilderOptions => {
+      return buildInitialState({
+        ...state,
+        expression: action.payload.expression,
+      });
+    },
+  ],
+  [
+    BuilderOptionsActionType.SetSeriesFunctions,
+    (state: QueryBuilderOptions, action: BuilderOptionsReducerAction): QueryBuilderOptions => {
+      return buildInitialState({
+        ...state,
+        seriesFunctions: action.payload.seriesFunctions,
+      });
+    },
+  ],
+  [
+    BuilderOptionsActionType.SetTimeShift,
+    (state: QueryBuilderOptions, action: BuilderOptionsReducerAction): QueryBuilderOptions => {
+      return buildInitialState({
+        ...state,
+        timeShift: action.payload.timeShift,
+      });
+    },
+  ],
+  [
+    BuilderOptionsActionType.SetAggregation,
+    (state: QueryBuilderOptions, action: BuilderOptionsReducerAction): QueryBuilderOptions => {
+      return buildInitialState({
+        ...state,
+        aggregation: action.payload.aggregation,
+      });
+    },
+  ],
+  [
+    BuilderOptionsActionType.SetForecast,
+    (state: QueryBuilderOptions, action: BuilderOptionsReducerAction): QueryBuilderOptions => {
+      return buildInitialState({
+        ...state,
+        forecast: action.payload.forecast,
+      });
+    },
+  ],
+  [
+    BuilderOptionsActionType.SetDisableDownsampling,
+    (state: QueryBuilderOptions, action: BuilderOptionsReducerAction): QueryBuilderOptions => {
+      return buildInitialState({
+        ...state,
+        disableDownsampling: action.payload.disableDownsampling,
+      });
+    },
+  ],
 ]);
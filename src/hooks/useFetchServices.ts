@@ -0,0 +1,6 @@
+import { SERVICES } from '../queryparser/constants';
+import { DataSource } from '../datasource';
+
+export default (datasource: DataSource): readonly string[] => {
+  return SERVICES;
+};
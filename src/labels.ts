@@ -36,6 +36,11 @@ export default {
         tooltip: 'Resource Kind to use in query',
         empty: '<select resource kind>',
       },
+      AdapterInstanceIdInput: {
+        label: 'Adapter Instance',
+        tooltip: 'Id of a single adapter instance to show, all instances of the adapter kind are shown by default',
+        empty: '<adapter instance id>',
+      },
     },
     filters: {
       WhereHealthSelect: {
@@ -58,6 +63,16 @@ export default {
         tooltip: 'Tag criteria for filtering',
         empty: '<select tag>',
       },
+      WhereServiceSelect: {
+        label: 'Where Service',
+        tooltip: 'Services of the Aria node to show, all services are shown by default',
+        empty: '<select service>',
+      },
+      ExcludeMaintainedSwitch: {
+        label: 'Exclude Maintained',
+        tooltip: 'Skips resources which are currently in maintenance',
+        empty: '',
+      },
     },
     collectors: {
       WithMetricSelect: {
@@ -70,6 +85,58 @@ export default {
         tooltip: 'Property collector criteria',
         empty: '<select property>',
       },
+      WithSuperMetricInput: {
+        label: 'With Super Metric',
+        tooltip: 'Name of a super metric to query instead of a metric',
+        empty: '<super metric name>',
+      },
+    },
+    transformations: {
+      FormulaInput: {
+        label: 'Formula',
+        tooltip: 'Aria super metric formula evaluated for every resource, e.g. avg(${this, metric=cpu|usage_average})',
+        empty: '<formula>',
+      },
+      ExpressionInput: {
+        label: 'Expression',
+        tooltip: 'Combines the metrics of the query, e.g. mem|consumed / mem|guest_provisioned * 100',
+        empty: '<expression>',
+      },
+      SeriesFunctionSelect: {
+        label: 'Function',
+        tooltip: 'Functions are applied in order to every metric series. Windows are durations such as 30m or 1d.',
+        empty: '<select function>',
+      },
+      TimeShiftInput: {
+        label: 'Time Shift',
+        tooltip: 'Adds the series shifted by each duration, e.g. 1d or 1w, labelled with their offset',
+        empty: '<duration>',
+      },
+      AggregationSelect: {
+        label: 'Aggregate',
+        tooltip: 'Combines the series per group of label values, series of different metrics are never combined',
+        empty: '<select operator>',
+      },
+      GroupBySelect: {
+        label: 'Group By',
+        tooltip: 'Labels or properties forming the groups, all series form one group by default',
+        empty: '<select labels>',
+      },
+      ForecastSelect: {
+        label: 'Forecast',
+        tooltip: 'Predicts the series for the horizon, e.g. 30d',
+        empty: '<select model>',
+      },
+      ForecastOutputSelect: {
+        label: 'Output',
+        tooltip: 'Series with their forecast or the time until the threshold is reached',
+        empty: '<select output>',
+      },
+      DisableDownsamplingSwitch: {
+        label: 'All Points',
+        tooltip: 'Returns all points instead of reducing series to the max data points of the panel',
+        empty: '',
+      },
     },
   },
 
@@ -81,6 +148,11 @@ export default {
     QueryType: {
       table: 'Table',
       timeseries: 'Time Series',
+      formula: 'Formula',
+      platformHealth: 'Platform Health',
+      collectors: 'Collectors',
+      adapterInstances: 'Adapter Instances',
+      maintenance: 'Maintenance',
     },
   },
 };
@@ -32,15 +32,27 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 
 export const LANG_ID = 'aria-operations';
 
-export const FUNCTIONS = ['adapterKind', 'resourceKind'];
+export const FUNCTIONS = ['adapterKind', 'resourceKind', 'adapterInstanceId'];
 
-export const FILTERS = ['whereHealth', 'whereState', 'whereStatus', 'whereTag'];
+export const FILTERS = ['whereHealth', 'whereState', 'whereStatus', 'whereTag', 'whereService', 'excludeMaintained'];
 
-export const COLLECTORS = ['withMetric', 'withProperty'];
+export const COLLECTORS = ['withMetric', 'withSuperMetric', 'withProperty'];
 
 export const CUSTOM_FILTERS = ['where'];
 
-export const KEYWORDS = [...FUNCTIONS, ...FILTERS, ...COLLECTORS, ...CUSTOM_FILTERS];
+// Transformations are applied by the backend to the series of the query, in this order
+export const TRANSFORMATIONS = [
+  'formula',
+  'series',
+  'timeShift',
+  'expression',
+  'aggregate',
+  'groupBy',
+  'forecast',
+  'disableDownsampling',
+];
+
+export const KEYWORDS = [...FUNCTIONS, ...FILTERS, ...COLLECTORS, ...CUSTOM_FILTERS, ...TRANSFORMATIONS];
 
 export const STATES = [
   'STOPPED',
@@ -71,6 +83,8 @@ export const STATUSES = [
 
 export const HEALTH = ['GREEN', 'YELLOW', 'ORANGE', 'RED', 'GREY'];
 
+export const SERVICES = ['ADMINUI', 'ANALYTICS', 'API', 'CASA', 'CASSANDRA', 'COLLECTOR', 'LOCATOR', 'UI'];
+
 // export const KEYWORDS = [
 //     'resource',
 //     'adapter',
@@ -31,8 +31,9 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 */
 
 import type { Monaco, monacoTypes } from '@grafana/ui';
-import { HEALTH, KEYWORDS, STATES, STATUSES } from 'queryparser/constants';
+import { HEALTH, KEYWORDS, SERVICES, STATES, STATUSES } from 'queryparser/constants';
 import { KeyValue } from 'types';
+import { AggregationOperator, ForecastModel, SeriesFunctionType } from 'types/queryBuilder';
 import { DataSource } from '../../datasource';
 import { retrieveQueryParams } from '../utils';
 
@@ -155,6 +156,42 @@ export class AriaOpsCompletionItemProvider implements monacoTypes.languages.Comp
     };
   };
 
+  private handleService = (
+    text: string,
+    range: monacoTypes.IRange
+  ): monacoTypes.languages.ProviderResult<monacoTypes.languages.CompletionList> => {
+    return {
+      suggestions: SERVICES.map((label) => this.makeCompletionItem(label, range, '')) || [],
+    };
+  };
+
+  private handleSeries = (
+    text: string,
+    range: monacoTypes.IRange
+  ): monacoTypes.languages.ProviderResult<monacoTypes.languages.CompletionList> => {
+    return {
+      suggestions: Object.values(SeriesFunctionType).map((label) => this.makeCompletionItem(label, range, ',')),
+    };
+  };
+
+  private handleAggregate = (
+    text: string,
+    range: monacoTypes.IRange
+  ): monacoTypes.languages.ProviderResult<monacoTypes.languages.CompletionList> => {
+    return {
+      suggestions: Object.values(AggregationOperator).map((label) => this.makeCompletionItem(label, range, '')),
+    };
+  };
+
+  private handleForecast = (
+    text: string,
+    range: monacoTypes.IRange
+  ): monacoTypes.languages.ProviderResult<monacoTypes.languages.CompletionList> => {
+    return {
+      suggestions: Object.values(ForecastModel).map((label) => this.makeCompletionItem(label, range, ',')),
+    };
+  };
+
   private handleWhere = (
     text: string,
     range: monacoTypes.IRange
@@ -184,6 +221,11 @@ export class AriaOpsCompletionItemProvider implements monacoTypes.languages.Comp
     whereHealth: this.handleHealth,
     whereState: this.handleState,
     whereStatus: this.handleStatus,
+    // Must precede where, which is found at the same position
+    whereService: this.handleService,
+    series: this.handleSeries,
+    aggregate: this.handleAggregate,
+    forecast: this.handleForecast,
     where: this.handleWhere,
   };
 
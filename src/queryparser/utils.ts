@@ -7,49 +7,120 @@ import {
   QueryBuilderOptionsBase,
 } from '../types/queryBuilder';
 
+/**
+ * Splits a query into its calls, e.g. adapterKind(VMWARE).where(name=~vm.*) into [adapterKind, VMWARE] and
+ * [where, name=~vm.*]. Parentheses within an argument are matched, so formulas and expressions can be passed.
+ */
+export const parseCalls = (query: string): Array<[string, string]> => {
+  const calls: Array<[string, string]> = [];
+  const regexp = new RegExp(`(\\w+)\\(`, 'g');
+  let match: RegExpExecArray | null;
+  while ((match = regexp.exec(query)) !== null) {
+    let depth = 1;
+    let end = regexp.lastIndex;
+    for (; end < query.length && depth > 0; end++) {
+      if (query[end] === '(') {
+        depth++;
+      } else if (query[end] === ')') {
+        depth--;
+      }
+    }
+    calls.push([match[1], query.slice(regexp.lastIndex, depth === 0 ? end - 1 : end)]);
+    regexp.lastIndex = end;
+  }
+  return calls;
+};
+
+const parseNumber = (value?: string): number | undefined => {
+  const number = Number(value);
+  return value && !isNaN(number) ? number : undefined;
+};
+
 export const retrieveQueryParams = (query: string): QueryBuilderOptionsBase => {
-  const builderOptions: QueryBuilderOptionsBase = defaultBuilderQuery.builderOptions;
-  Object.keys(builderOptions.functions).map((key) => {
-    const word = key as keyof Functions;
-    const regexp = new RegExp(`\\s*${word}\\(([^)]+)`);
-    const match = query.match(regexp);
-    if (match && match[1]) {
-      builderOptions.functions[word] = match[1];
+  // The defaults are copied, parsing must not change them
+  const builderOptions: QueryBuilderOptionsBase = JSON.parse(JSON.stringify(defaultBuilderQuery.builderOptions));
+  const functions = Object.keys(builderOptions.functions);
+  const collectors = Object.keys(builderOptions.collectors);
+  const filters = Object.keys(builderOptions.filters).filter((key) => key !== 'excludeMaintained');
+  const regexp_exp = new RegExp(`(\\w*\\|?\\w*)(=~|!~|=|!=)(.*)`);
+  for (const [word, argument] of parseCalls(query)) {
+    if (!argument) {
+      continue;
     }
-  });
-  Object.keys(builderOptions.collectors).map((key) => {
-    const word = key as keyof Collectors;
-    const regexp = new RegExp(`\\s*${word}\\(([^)]+)`);
-    const match = query.match(regexp);
-    if (match && match[1]) {
-      builderOptions.collectors[word] = match[1].split(',');
+    if (functions.includes(word)) {
+      builderOptions.functions[word as keyof Functions] = argument;
+    } else if (collectors.includes(word)) {
+      builderOptions.collectors[word as keyof Collectors] = argument.split(',');
+    } else if (filters.includes(word)) {
+      builderOptions.filters[word as Exclude<keyof Filters, 'excludeMaintained'>] = argument.split(',');
     }
-  });
-  Object.keys(builderOptions.filters).map((key) => {
-    const word = key as keyof Filters;
-    const regexp = new RegExp(`\\s*${word}\\(([^)]+)`);
-    const match = query.match(regexp);
-    if (match && match[1]) {
-      builderOptions.filters[word] = match[1].split(',');
+    switch (word) {
+      case 'excludeMaintained': {
+        builderOptions.filters.excludeMaintained = argument === 'true';
+        break;
+      }
+      case 'where': {
+        const exp = argument.match(regexp_exp);
+        if (exp) {
+          builderOptions.customFilters.push({
+            type: exp[1] || null,
+            operand: (exp[2] as Operand) || null,
+            value: exp[3] || '',
+          });
+        }
+        break;
+      }
+      case 'formula': {
+        builderOptions.formula = argument;
+        break;
+      }
+      case 'expression': {
+        builderOptions.expression = argument;
+        break;
+      }
+      case 'series': {
+        const [name, window, alpha] = argument.split(',');
+        builderOptions.seriesFunctions.push({ function: name, window: window || undefined, alpha: parseNumber(alpha) });
+        break;
+      }
+      case 'timeShift': {
+        builderOptions.timeShift = argument.split(',');
+        break;
+      }
+      case 'aggregate': {
+        const [operator, percentile] = argument.split(',');
+        builderOptions.aggregation.operator = operator;
+        builderOptions.aggregation.percentile = parseNumber(percentile);
+        break;
+      }
+      case 'groupBy': {
+        builderOptions.aggregation.groupBy = argument.split(',');
+        break;
+      }
+      case 'forecast': {
+        // The model and horizon are followed by optional settings, e.g. forecast(holtWinters,30d,season=7d)
+        const [model, horizon, ...settings] = argument.split(',');
+        builderOptions.forecast = { model, horizon: horizon || '' };
+        settings.forEach((setting) => {
+          const [key, value] = setting.split('=');
+          switch (key) {
+            case 'season':
+            case 'output':
+              builderOptions.forecast[key] = value;
+              break;
+            case 'confidence':
+            case 'threshold':
+              builderOptions.forecast[key] = parseNumber(value);
+              break;
+          }
+        });
+        break;
+      }
+      case 'disableDownsampling': {
+        builderOptions.disableDownsampling = argument === 'true';
+        break;
+      }
     }
-  });
-  const regexp = new RegExp(`\\s*where\\(([^)]+)`, 'g');
-  const matchesIterator = query.matchAll(regexp);
-  const regexp_exp = new RegExp(`(\\w*\\|?\\w*)(=~|!~|=|!=)(.*)`);
-  let k = 0;
-  for (const match of matchesIterator) {
-    const capturedGroup = match.slice(1);
-    capturedGroup.map((g) => {
-      const exp = g.match(regexp_exp);
-      if (exp) {
-        builderOptions.customFilters[k] = {
-          type: exp[1] || null,
-          operand: (exp[2] as Operand) || null,
-          value: exp[3] || '',
-        };
-      }
-    });
-    k++;
   }
   return builderOptions;
 };
@@ -16,7 +16,15 @@ export interface AriaSourceOptions extends DataSourceJsonData {
   host: string;
   username: string;
   authSource: string;
+  authMode?: string;
+  oauthPassThru?: boolean;
   tlsSkipVerify: boolean;
+  serverName?: string;
+  proxyUrl?: string;
+  rateLimit?: number;
+  connectTimeout?: number;
+  requestTimeout?: number;
+  queryTimeout?: number;
 }
 
 /**
@@ -24,6 +32,11 @@ export interface AriaSourceOptions extends DataSourceJsonData {
  */
 export interface AriaSecureJsonData {
   password?: string;
+  apiToken?: string;
+  tokenExchangeUrl?: string;
+  tlsCACert?: string;
+  tlsClientCert?: string;
+  tlsClientKey?: string;
 }
 export interface KeyValue<T> {
   [key: string]: T;
@@ -9,12 +9,24 @@ import { AriaSourceOptions } from '../types';
 export enum QueryType {
   Table = 'table',
   TimeSeries = 'timeseries',
+  Formula = 'formula',
+  PlatformHealth = 'platformHealth',
+  Collectors = 'collectors',
+  AdapterInstances = 'adapterInstances',
+  Maintenance = 'maintenance',
 }
 
+/**
+ * Query types which select resources by adapter kind, resource kind and filters.
+ */
+export const resourceQueryTypes = [QueryType.Table, QueryType.TimeSeries, QueryType.Formula];
+
 export interface Functions {
   adapterKind: string;
   resourceKind: string;
   withMetric: string;
+  withSuperMetric?: string;
+  adapterInstanceId?: string;
 }
 
 export interface Filters {
@@ -22,6 +34,8 @@ export interface Filters {
   whereState: string[];
   whereStatus: string[];
   whereTag: string[];
+  whereService?: string[];
+  excludeMaintained?: boolean;
 }
 
 export interface Collectors {
@@ -45,11 +59,76 @@ export interface CustomFilter {
   value: string | null;
 }
 
+export enum AggregationOperator {
+  Sum = 'sum',
+  Avg = 'avg',
+  Min = 'min',
+  Max = 'max',
+  Count = 'count',
+  Percentile = 'percentile',
+}
+
+/**
+ * Aggregation combines the series of a query per group of label values.
+ */
+export interface Aggregation {
+  operator: AggregationOperator | string;
+  groupBy: string[];
+  percentile?: number;
+}
+
+export enum SeriesFunctionType {
+  Rate = 'rate',
+  Delta = 'delta',
+  Derivative = 'derivative',
+  MovingAvg = 'movingAvg',
+  MovingMax = 'movingMax',
+  ExponentialSmoothing = 'exponentialSmoothing',
+}
+
+/**
+ * SeriesFunction transforms every metric series, the window is a duration such as 30m or 1d.
+ */
+export interface SeriesFunction {
+  function: SeriesFunctionType | string;
+  window?: string;
+  alpha?: number;
+}
+
+export enum ForecastModel {
+  Linear = 'linear',
+  HoltWinters = 'holtWinters',
+}
+
+export enum ForecastOutput {
+  Series = 'series',
+  TimeUntilThreshold = 'timeUntilThreshold',
+}
+
+/**
+ * Forecast predicts the series of a query for a horizon such as 30d.
+ */
+export interface Forecast {
+  model: ForecastModel | string;
+  horizon: string;
+  season?: string;
+  confidence?: number;
+  output?: ForecastOutput | string;
+  threshold?: number;
+}
+
 export interface QueryBuilderOptionsBase {
   functions: Functions;
   filters: Filters;
   collectors: Collectors;
   customFilters: CustomFilter[];
+  aggregation: Aggregation;
+  formula: string;
+  expression: string;
+  seriesFunctions: SeriesFunction[];
+  forecast: Forecast;
+  timeShift: string[];
+  disableDownsampling: boolean;
 }
 
 export interface QueryBuilderOptions extends QueryBuilderOptionsBase {
@@ -94,19 +173,47 @@ export const defaultBuilderQuery: Omit<AriaQuery, 'refId'> = {
       adapterKind: '',
       resourceKind: '',
       withMetric: '',
+      withSuperMetric: '',
+      adapterInstanceId: '',
     },
     filters: {
       whereHealth: [],
       whereState: [],
       whereStatus: [],
       whereTag: [],
+      whereService: [],
+      excludeMaintained: false,
     },
     collectors: {
       withProperty: [],
     },
     customFilters: [],
+    aggregation: {
+      operator: '',
+      groupBy: [],
+    },
+    formula: '',
+    expression: '',
+    seriesFunctions: [],
+    forecast: {
+      model: '',
+      horizon: '',
+    },
+    timeShift: [],
+    disableDownsampling: false,
     queryType: QueryType.TimeSeries,
   },
 };
 
+/**
+ * Annotations show the maintenance windows of Aria by default.
+ */
+export const defaultAnnotationQuery: Omit<AriaQuery, 'refId'> = {
+  ...defaultBuilderQuery,
+  builderOptions: {
+    ...defaultBuilderQuery.builderOptions,
+    queryType: QueryType.Maintenance,
+  },
+};
+
 export type vROPsQueryEditorProps = QueryEditorProps<DataSource, AriaQuery, AriaSourceOptions>;
@@ -0,0 +1,43 @@
+import React from 'react';
+import { InlineField, Input, Stack } from '@grafana/ui';
+import { DataSource } from '../datasource';
+import { QueryBuilderOptions } from '../types/queryBuilder';
+import { BuilderOptionsReducerAction, setAdapterInstanceId, setAdapterKind } from '../hooks/useBuilderOptionsState';
+import { SingleSelectAdapterResourceKind } from '../components/queryBuilder/Select';
+import useFetchAdapterResourceKinds from '../hooks/useFetchAdapterResourceKinds';
+import labels from '../labels';
+
+interface AdapterInstancesQueryBuilderProps {
+  datasource: DataSource;
+  builderOptions: QueryBuilderOptions;
+  builderOptionsDispatch: React.Dispatch<BuilderOptionsReducerAction>;
+}
+
+export const AdapterInstancesQueryBuilder = (props: AdapterInstancesQueryBuilderProps) => {
+  const { datasource, builderOptions, builderOptionsDispatch } = props;
+  const adapterResourceKinds = useFetchAdapterResourceKinds(datasource);
+  const adapterInstance = labels.components.functions.AdapterInstanceIdInput;
+
+  const onAdapterKindChange = (adapterKind: string) => builderOptionsDispatch(setAdapterKind(adapterKind));
+  const onAdapterInstanceIdChange = (adapterInstanceId: string) =>
+    builderOptionsDispatch(setAdapterInstanceId(adapterInstanceId));
+
+  return (
+    <Stack direction="row" wrap="wrap" alignItems="start" justifyContent="start" gap={0}>
+      <SingleSelectAdapterResourceKind
+        labels={labels.components.functions.AdapterKindSelect}
+        fetchedOptions={Object.keys(adapterResourceKinds)}
+        changeFunction={onAdapterKindChange}
+        value={builderOptions.functions.adapterKind}
+      />
+      <InlineField labelWidth={17} label={adapterInstance.label} tooltip={adapterInstance.tooltip}>
+        <Input
+          value={builderOptions.functions.adapterInstanceId || ''}
+          placeholder={adapterInstance.empty}
+          onChange={(e) => onAdapterInstanceIdChange(e.currentTarget.value)}
+          width={40}
+        />
+      </InlineField>
+    </Stack>
+  );
+};
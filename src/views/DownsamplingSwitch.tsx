@@ -0,0 +1,19 @@
+import React from 'react';
+import { InlineField, InlineSwitch } from '@grafana/ui';
+import labels from '../labels';
+
+interface DownsamplingSwitchProps {
+  disableDownsampling: boolean;
+  onChange: (disableDownsampling: boolean) => void;
+}
+
+export const DownsamplingSwitch = (props: DownsamplingSwitchProps) => {
+  const { disableDownsampling, onChange } = props;
+  const { label, tooltip } = labels.components.transformations.DisableDownsamplingSwitch;
+
+  return (
+    <InlineField labelWidth={17} label={label} tooltip={tooltip}>
+      <InlineSwitch value={disableDownsampling} onChange={(e) => onChange(e.currentTarget.checked)} />
+    </InlineField>
+  );
+};
@@ -0,0 +1,51 @@
+import React from 'react';
+import { InlineField, Input, Stack } from '@grafana/ui';
+import { DataSource } from '../datasource';
+import { Aggregation, Forecast, QueryBuilderOptions } from '../types/queryBuilder';
+import {
+  BuilderOptionsReducerAction,
+  setAggregation,
+  setDisableDownsampling,
+  setForecast,
+  setFormula,
+} from '../hooks/useBuilderOptionsState';
+import { AggregationForm } from '../components/queryBuilder/AggregationForm';
+import { ForecastForm } from '../components/queryBuilder/ForecastForm';
+import { DownsamplingSwitch } from './DownsamplingSwitch';
+import labels from '../labels';
+
+interface FormulaQueryBuilderProps {
+  datasource: DataSource;
+  builderOptions: QueryBuilderOptions;
+  builderOptionsDispatch: React.Dispatch<BuilderOptionsReducerAction>;
+}
+
+export const FormulaQueryBuilder = (props: FormulaQueryBuilderProps) => {
+  const { datasource, builderOptions, builderOptionsDispatch } = props;
+  const formula = labels.components.transformations.FormulaInput;
+
+  const onFormulaChange = (formula: string) => builderOptionsDispatch(setFormula(formula));
+  const onAggregationChange = (aggregation: Aggregation) => builderOptionsDispatch(setAggregation(aggregation));
+  const onForecastChange = (forecast: Forecast) => builderOptionsDispatch(setForecast(forecast));
+  const onDisableDownsamplingChange = (disableDownsampling: boolean) =>
+    builderOptionsDispatch(setDisableDownsampling(disableDownsampling));
+
+  return (
+    <Stack direction="column" gap={0}>
+      <InlineField labelWidth={17} label={formula.label} tooltip={formula.tooltip}>
+        <Input
+          value={builderOptions.formula || ''}
+          placeholder={formula.empty}
+          onChange={(e) => onFormulaChange(e.currentTarget.value)}
+          width={68}
+        />
+      </InlineField>
+      <AggregationForm datasource={datasource} builderOptions={builderOptions} changeFunction={onAggregationChange} />
+      <ForecastForm forecast={builderOptions.forecast} changeFunction={onForecastChange} />
+      <DownsamplingSwitch
+        disableDownsampling={builderOptions.disableDownsampling || false}
+        onChange={onDisableDownsamplingChange}
+      />
+    </Stack>
+  );
+};
@@ -0,0 +1,26 @@
+import React from 'react';
+import { DataSource } from '../datasource';
+import { CustomFilter, QueryBuilderOptions } from '../types/queryBuilder';
+import { BuilderOptionsReducerAction, setWithFilters } from '../hooks/useBuilderOptionsState';
+import { CustomFilterForm } from '../components/queryBuilder/CustomFiltersForm';
+
+interface MaintenanceQueryBuilderProps {
+  datasource: DataSource;
+  builderOptions: QueryBuilderOptions;
+  builderOptionsDispatch: React.Dispatch<BuilderOptionsReducerAction>;
+}
+
+export const MaintenanceQueryBuilder = (props: MaintenanceQueryBuilderProps) => {
+  const { builderOptions, builderOptionsDispatch } = props;
+
+  const onWithFiltersChange = (customFilters: CustomFilter[]) => builderOptionsDispatch(setWithFilters(customFilters));
+
+  return (
+    <CustomFilterForm
+      changeFunction={onWithFiltersChange}
+      builderOptions={builderOptions}
+      types={['name']}
+      tooltip="The maintenance schedules can be filtered by their name. The result will be Union (AND) of all conditions."
+    />
+  );
+};
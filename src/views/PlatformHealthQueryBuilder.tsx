@@ -0,0 +1,30 @@
+import React from 'react';
+import { DataSource } from '../datasource';
+import { QueryBuilderOptions } from '../types/queryBuilder';
+import { BuilderOptionsReducerAction, setWhereService } from '../hooks/useBuilderOptionsState';
+import { MultiSelect } from '../components/queryBuilder/Select';
+import useFetchServices from '../hooks/useFetchServices';
+import labels from '../labels';
+
+interface PlatformHealthQueryBuilderProps {
+  datasource: DataSource;
+  builderOptions: QueryBuilderOptions;
+  builderOptionsDispatch: React.Dispatch<BuilderOptionsReducerAction>;
+}
+
+export const PlatformHealthQueryBuilder = (props: PlatformHealthQueryBuilderProps) => {
+  const { datasource, builderOptions, builderOptionsDispatch } = props;
+
+  const onWhereServiceChange = (whereService: string[]) => builderOptionsDispatch(setWhereService(whereService));
+
+  return (
+    <MultiSelect
+      datasource={datasource}
+      builderOptions={builderOptions}
+      values={builderOptions.filters.whereService || []}
+      changeFunction={onWhereServiceChange}
+      labels={labels.components.filters.WhereServiceSelect}
+      useFetch={useFetchServices}
+    />
+  );
+};
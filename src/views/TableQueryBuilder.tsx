@@ -1,20 +1,25 @@
 import React from 'react';
 import { DataSource } from '../datasource';
-import { QueryBuilderOptions } from '../types/queryBuilder';
-import { BuilderOptionsReducerAction } from '../hooks/useBuilderOptionsState';
+import { QueryBuilderOptions, SeriesFunction } from '../types/queryBuilder';
+import { BuilderOptionsReducerAction, setSeriesFunctions } from '../hooks/useBuilderOptionsState';
+import { SeriesFunctionsForm } from '../components/queryBuilder/SeriesFunctionsForm';
 
-interface TimeSeriesQueryBuilderProps {
+interface TableQueryBuilderProps {
   datasource: DataSource;
   builderOptions: QueryBuilderOptions;
   builderOptionsDispatch: React.Dispatch<BuilderOptionsReducerAction>;
 }
 
-// interface TimeSeriesQueryBuilderState {
-// }
+export const TableQueryBuilder = (props: TableQueryBuilderProps) => {
+  const { builderOptions, builderOptionsDispatch } = props;
 
-export const TableQueryBuilder = (props: TimeSeriesQueryBuilderProps) => {
-  // const { datasource, builderOptions, builderOptionsDispatch } = props;
-  // const builderState: TimeSeriesQueryBuilderState = useMemo(() => ({
-  // }), [builderOptions]);
-  return <div></div>;
+  const onSeriesFunctionsChange = (seriesFunctions: SeriesFunction[]) =>
+    builderOptionsDispatch(setSeriesFunctions(seriesFunctions));
+
+  return (
+    <SeriesFunctionsForm
+      seriesFunctions={builderOptions.seriesFunctions || []}
+      changeFunction={onSeriesFunctionsChange}
+    />
+  );
 };
@@ -1,7 +1,22 @@
 import React from 'react';
+import { InlineField, Input, Stack } from '@grafana/ui';
 import { DataSource } from '../datasource';
-import { QueryBuilderOptions } from '../types/queryBuilder';
-import { BuilderOptionsReducerAction } from '../hooks/useBuilderOptionsState';
+import { Aggregation, Forecast, QueryBuilderOptions, SeriesFunction } from '../types/queryBuilder';
+import {
+  BuilderOptionsReducerAction,
+  setAggregation,
+  setDisableDownsampling,
+  setExpression,
+  setForecast,
+  setSeriesFunctions,
+  setTimeShift,
+} from '../hooks/useBuilderOptionsState';
+import { SeriesFunctionsForm } from '../components/queryBuilder/SeriesFunctionsForm';
+import { AggregationForm } from '../components/queryBuilder/AggregationForm';
+import { ForecastForm } from '../components/queryBuilder/ForecastForm';
+import { MultiSelectMetricPropertyTag } from '../components/queryBuilder/Select';
+import { DownsamplingSwitch } from './DownsamplingSwitch';
+import labels from '../labels';
 
 interface TimeSeriesQueryBuilderProps {
   datasource: DataSource;
@@ -9,12 +24,48 @@ interface TimeSeriesQueryBuilderProps {
   builderOptionsDispatch: React.Dispatch<BuilderOptionsReducerAction>;
 }
 
-// interface TimeSeriesQueryBuilderState {
-// }
+// Offered shifts, any other duration can be entered
+const timeShifts = ['1h', '1d', '1w', '30d'];
 
 export const TimeSeriesQueryBuilder = (props: TimeSeriesQueryBuilderProps) => {
-  // const { datasource, builderOptions, builderOptionsDispatch } = props;
-  // const builderState: TimeSeriesQueryBuilderState = useMemo(() => ({
-  // }), [builderOptions]);
-  return <div></div>;
+  const { datasource, builderOptions, builderOptionsDispatch } = props;
+  const expression = labels.components.transformations.ExpressionInput;
+
+  const onExpressionChange = (expression: string) => builderOptionsDispatch(setExpression(expression));
+  const onSeriesFunctionsChange = (seriesFunctions: SeriesFunction[]) =>
+    builderOptionsDispatch(setSeriesFunctions(seriesFunctions));
+  const onTimeShiftChange = (timeShift: string[]) => builderOptionsDispatch(setTimeShift(timeShift));
+  const onAggregationChange = (aggregation: Aggregation) => builderOptionsDispatch(setAggregation(aggregation));
+  const onForecastChange = (forecast: Forecast) => builderOptionsDispatch(setForecast(forecast));
+  const onDisableDownsamplingChange = (disableDownsampling: boolean) =>
+    builderOptionsDispatch(setDisableDownsampling(disableDownsampling));
+
+  return (
+    <Stack direction="column" gap={0}>
+      <InlineField labelWidth={17} label={expression.label} tooltip={expression.tooltip}>
+        <Input
+          value={builderOptions.expression || ''}
+          placeholder={expression.empty}
+          onChange={(e) => onExpressionChange(e.currentTarget.value)}
+          width={68}
+        />
+      </InlineField>
+      <SeriesFunctionsForm
+        seriesFunctions={builderOptions.seriesFunctions || []}
+        changeFunction={onSeriesFunctionsChange}
+      />
+      <MultiSelectMetricPropertyTag
+        labels={labels.components.transformations.TimeShiftInput}
+        fetchedOptions={timeShifts}
+        changeFunction={onTimeShiftChange}
+        values={builderOptions.timeShift || []}
+      />
+      <AggregationForm datasource={datasource} builderOptions={builderOptions} changeFunction={onAggregationChange} />
+      <ForecastForm forecast={builderOptions.forecast} changeFunction={onForecastChange} />
+      <DownsamplingSwitch
+        disableDownsampling={builderOptions.disableDownsampling || false}
+        onChange={onDisableDownsamplingChange}
+      />
+    </Stack>
+  );
 };